@@ -23,13 +23,26 @@ func main() {
 	}
 	defer db.Close()
 	// Initialize services
+	backend, err := services.NewLLMBackend(cfg)
+	if err != nil {
+		log.Fatalf("LLM backend initialization failed: %v", err)
+	}
 	modelService := services.NewModelService(cfg, db)
-	documentService := services.NewDocumentService(db, cfg)
+	if err := modelService.Reconcile(); err != nil {
+		log.Printf("Warning: failed to reconcile models directory: %v", err)
+	}
+	embeddingService := services.NewEmbeddingService(cfg, backend)
+	documentService := services.NewDocumentService(db, cfg, embeddingService)
+	if err := documentService.StartIngestion(); err != nil {
+		log.Fatalf("Document ingestion failed to start: %v", err)
+	}
 	wikiService := services.NewWikiService()
-	aiService := services.NewAIService(cfg)
+	aiService := services.NewAIService(cfg, backend)
+	chatService := services.NewChatService(db, cfg, aiService)
+	promptService := services.NewPromptService(db)
 
 	// Initialize handlers
-	h := handlers.New(modelService, documentService, wikiService, aiService)
+	h := handlers.New(modelService, documentService, wikiService, aiService, chatService, promptService)
 
 	// Setup Gin router
 	r := gin.Default()
@@ -54,7 +67,13 @@ func main() {
 		{
 			models.GET("", h.ListModels)
 			models.POST("/download", h.DownloadModel)
+			models.GET("/downloads", h.ListDownloads)
+			models.GET("/downloads/:name", h.GetDownload)
+			models.POST("/downloads/:name/cancel", h.CancelDownload)
 			models.POST("/load", h.LoadModel)
+			models.GET("/load/:name", h.GetModelLoad)
+			models.POST("/load/cancel", h.CancelModelLoad)
+			models.POST("/import", h.ImportModel)
 			models.DELETE("/:name", h.DeleteModel)
 		}
 
@@ -62,6 +81,7 @@ func main() {
 		documents := api.Group("/documents")
 		{
 			documents.GET("", h.ListDocuments)
+			documents.GET("/:id", h.GetDocument)
 			documents.POST("/upload", h.UploadDocument)
 			documents.DELETE("/:id", h.DeleteDocument)
 		}
@@ -74,6 +94,28 @@ func main() {
 
 		// AI Query
 		api.POST("/query", h.Query)
+		api.POST("/query/stream", h.QueryStream)
+
+		// Chat sessions
+		chat := api.Group("/chat/sessions")
+		{
+			chat.GET("", h.ListChatSessions)
+			chat.POST("", h.CreateChatSession)
+			chat.GET("/:id", h.GetChatSession)
+			chat.DELETE("/:id", h.DeleteChatSession)
+			chat.POST("/:id/messages", h.SendChatMessage)
+		}
+
+		// Prompt templates
+		prompts := api.Group("/prompts")
+		{
+			prompts.GET("", h.ListPrompts)
+			prompts.POST("", h.CreatePrompt)
+			prompts.GET("/:name", h.GetPrompt)
+			prompts.PUT("/:name", h.UpdatePrompt)
+			prompts.DELETE("/:name", h.DeletePrompt)
+			prompts.GET("/:name/versions", h.ListPromptVersions)
+		}
 	}
 
 	log.Printf("Server starting on port %s", cfg.Port)
@@ -4,6 +4,8 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 )
 
 type Config struct {
@@ -12,8 +14,62 @@ type Config struct {
 	UploadsPath  string
 	DatabasePath string
 	OllamaURL    string
+	DefaultModel string // used when a request doesn't name a model
 	MaxFileSize  int64
 	AllowedTypes []string
+	ChunkSize    int
+	ChunkOverlap int
+
+	// Model runtime: ollama, openai (any OpenAI-compatible server) or llama
+	// (in-process, needs the llama build tag)
+	LLMBackend       string
+	OpenAIBaseURL    string
+	OpenAIAPIKey     string
+	LlamaContextSize int
+	LlamaGPULayers   int
+	LlamaThreads     int
+
+	IngestWorkers int
+	DedupePolicy  string // reject, existing or replace
+
+	EmbeddingModel     string
+	EmbeddingBatchSize int
+
+	// Hybrid retrieval: candidates per retriever and reciprocal rank fusion
+	HybridCandidates    int
+	HybridKeywordWeight float64
+	HybridVectorWeight  float64
+	HybridRRFK          float64
+
+	// Query rewriting: used when a request doesn't say, and the number of
+	// paraphrases retrieved next to the rewritten query
+	QueryRewrite        bool
+	QueryParaphrases    int
+	MaxQueryParaphrases int
+
+	ContextTokenBudget int // upper limit for retrieved sources in a prompt
+	ContextWindow      int // assumed when the backend doesn't report one
+	ChatHistoryBudget  int // tokens of earlier turns sent with a chat message
+
+	Generation GenerationConfig
+}
+
+// GenerationConfig holds the sampling defaults sent to Ollama and the limits
+// request options are clamped to. Nil defaults leave the value to the model.
+type GenerationConfig struct {
+	Temperature   *float64
+	TopK          *int
+	TopP          *float64
+	RepeatPenalty *float64
+	NumCtx        *int
+	NumPredict    *int // also the room reserved for the answer in the prompt
+	Seed          *int
+
+	MaxTemperature float64
+	MaxTopK        int
+	MaxNumCtx      int
+	MaxNumPredict  int
+	MaxStop        int // number of stop sequences
 }
 
 func Load() *Config {
@@ -44,8 +100,53 @@ func Load() *Config {
 		UploadsPath:  filepath.Join(appDir, "uploads"),
 		DatabasePath: dbPath,
 		OllamaURL:    getEnv("OLLAMA_URL", "http://localhost:11434"),
+		DefaultModel: getEnv("DEFAULT_MODEL", "llama3"),
 		MaxFileSize:  50 * 1024 * 1024, // 50MB
 		AllowedTypes: []string{".pdf", ".txt", ".docx", ".md"},
+		ChunkSize:    getEnvInt("CHUNK_SIZE", 1000),   // characters per chunk
+		ChunkOverlap: getEnvInt("CHUNK_OVERLAP", 200), // characters shared with the previous chunk
+
+		LLMBackend:       getEnv("LLM_BACKEND", "ollama"),
+		OpenAIBaseURL:    getEnv("OPENAI_BASE_URL", "http://localhost:8080/v1"),
+		OpenAIAPIKey:     os.Getenv("OPENAI_API_KEY"),
+		LlamaContextSize: getEnvInt("LLAMA_CONTEXT_SIZE", 4096),
+		LlamaGPULayers:   getEnvInt("LLAMA_GPU_LAYERS", 0),
+		LlamaThreads:     getEnvInt("LLAMA_THREADS", runtime.NumCPU()),
+
+		IngestWorkers: getEnvInt("INGEST_WORKERS", 2),
+		DedupePolicy:  getEnv("DEDUPE_POLICY", "existing"),
+
+		EmbeddingModel:     getEnv("EMBEDDING_MODEL", "nomic-embed-text"),
+		EmbeddingBatchSize: getEnvInt("EMBEDDING_BATCH_SIZE", 16),
+
+		HybridCandidates:    getEnvInt("HYBRID_CANDIDATES", 20),
+		HybridKeywordWeight: getEnvFloat("HYBRID_KEYWORD_WEIGHT", 1.0),
+		HybridVectorWeight:  getEnvFloat("HYBRID_VECTOR_WEIGHT", 1.0),
+		HybridRRFK:          getEnvFloat("HYBRID_RRF_K", 60),
+
+		QueryRewrite:        getEnvBool("QUERY_REWRITE", false),
+		QueryParaphrases:    getEnvInt("QUERY_PARAPHRASES", 2),
+		MaxQueryParaphrases: getEnvInt("MAX_QUERY_PARAPHRASES", 5),
+
+		ContextTokenBudget: getEnvInt("CONTEXT_TOKEN_BUDGET", 2048),
+		ContextWindow:      getEnvInt("CONTEXT_WINDOW", 2048), // Ollama's default num_ctx
+		ChatHistoryBudget:  getEnvInt("CHAT_HISTORY_BUDGET", 1536),
+
+		Generation: GenerationConfig{
+			Temperature:   getEnvOptionalFloat("GEN_TEMPERATURE"),
+			TopK:          getEnvOptionalInt("GEN_TOP_K"),
+			TopP:          getEnvOptionalFloat("GEN_TOP_P"),
+			RepeatPenalty: getEnvOptionalFloat("GEN_REPEAT_PENALTY"),
+			NumCtx:        getEnvOptionalInt("GEN_NUM_CTX"),
+			NumPredict:    getEnvOptionalInt("GEN_NUM_PREDICT"),
+			Seed:          getEnvOptionalInt("GEN_SEED"),
+
+			MaxTemperature: getEnvFloat("GEN_MAX_TEMPERATURE", 2.0),
+			MaxTopK:        getEnvInt("GEN_MAX_TOP_K", 100),
+			MaxNumCtx:      getEnvInt("GEN_MAX_NUM_CTX", 8192),
+			MaxNumPredict:  getEnvInt("GEN_MAX_NUM_PREDICT", 2048),
+			MaxStop:        getEnvInt("GEN_MAX_STOP", 4),
+		},
 	}
 }
 
@@ -59,3 +160,49 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvOptionalInt returns nil when the variable is unset or invalid
+func getEnvOptionalInt(key string) *int {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
+
+func getEnvOptionalFloat(key string) *float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return &parsed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"local-ai-project/backend/internal/services"
+	"local-ai-project/backend/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chat session handlers
+func (h *Handler) CreateChatSession(c *gin.Context) {
+	var req struct {
+		Title string `json:"title"`
+	}
+
+	// The body is optional, untitled sessions are named after their first message
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	session, err := h.chatService.CreateSession(req.Title)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"session": session})
+}
+
+func (h *Handler) ListChatSessions(c *gin.Context) {
+	sessions, err := h.chatService.ListSessions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+func (h *Handler) GetChatSession(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.chatService.GetSession(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session": session})
+}
+
+func (h *Handler) DeleteChatSession(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.chatService.DeleteSession(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chat session deleted successfully"})
+}
+
+func (h *Handler) SendChatMessage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	var req types.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := h.chatService.GetSession(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	model, ok := h.resolveModel(c, req.ModelName)
+	if !ok {
+		return
+	}
+
+	prompt, ok := h.resolvePrompt(c, req.Prompt, req.PromptVersion, req.Language)
+	if !ok {
+		return
+	}
+
+	startTime := time.Now()
+
+	// Earlier turns let the rewrite resolve follow-up questions
+	rewrite := h.aiService.RewriteQuery(model, req.Message, session.Messages, req.Rewrite, req.Paraphrases)
+	sources := h.retrieveSources(types.QueryRequest{
+		Query:            req.Message,
+		IncludeWiki:      req.IncludeWiki,
+		IncludeDocuments: req.IncludeDocuments,
+		MaxSources:       req.MaxSources,
+	}, rewrite)
+
+	reply, err := h.chatService.SendMessage(id, model, req.Message, sources, req.Options, prompt)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptTooLong) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, types.ChatResponse{
+		SessionID:        id,
+		Message:          *reply.Message,
+		Sources:          sources,
+		ModelUsed:        model,
+		ProcessingTime:   time.Since(startTime).Seconds(),
+		Citations:        reply.Citations,
+		InvalidCitations: reply.InvalidCitations,
+		DroppedSources:   reply.DroppedSources,
+		Rewrites:         rewrite,
+	})
+}
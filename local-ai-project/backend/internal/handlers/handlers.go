@@ -2,6 +2,8 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,15 +19,20 @@ type Handler struct {
 	documentService *services.DocumentService
 	wikiService     *services.WikiService
 	aiService       *services.AIService
+	chatService     *services.ChatService
+	promptService   *services.PromptService
 }
 
 func New(modelService *services.ModelService, documentService *services.DocumentService,
-	wikiService *services.WikiService, aiService *services.AIService) *Handler {
+	wikiService *services.WikiService, aiService *services.AIService, chatService *services.ChatService,
+	promptService *services.PromptService) *Handler {
 	return &Handler{
 		modelService:    modelService,
 		documentService: documentService,
 		wikiService:     wikiService,
 		aiService:       aiService,
+		chatService:     chatService,
+		promptService:   promptService,
 	}
 }
 
@@ -45,13 +52,52 @@ func (h *Handler) ListModels(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	models = append(models, h.aiService.Pulls()...)
 	c.JSON(http.StatusOK, gin.H{"models": models})
 }
 
+// DownloadModel starts a background download, progress is polled with
+// GET /models/downloads/:name
 func (h *Handler) DownloadModel(c *gin.Context) {
+	var req types.DownloadModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.modelService.DownloadModel(req.Name, req.URL, req.SHA256)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Model download started", "download": job})
+}
+
+func (h *Handler) ListDownloads(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"downloads": h.modelService.Downloads()})
+}
+
+func (h *Handler) GetDownload(c *gin.Context) {
+	job, err := h.modelService.Download(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"download": job})
+}
+
+func (h *Handler) CancelDownload(c *gin.Context) {
+	if err := h.modelService.CancelDownload(c.Param("name")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Model download cancelled"})
+}
+
+func (h *Handler) LoadModel(c *gin.Context) {
 	var req struct {
 		Name string `json:"name" binding:"required"`
-		URL  string `json:"url" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -59,15 +105,43 @@ func (h *Handler) DownloadModel(c *gin.Context) {
 		return
 	}
 
-	if err := h.modelService.DownloadModel(req.Name, req.URL); err != nil {
+	model, err := h.aiService.LoadModel(req.Name, func() {
+		if err := h.modelService.MarkUsed(req.Name); err != nil {
+			log.Printf("Warning: failed to record use of model %s: %v", req.Name, err)
+		}
+	})
+	if err != nil {
+		var unknown *services.UnknownModelError
+		if errors.As(err, &unknown) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "available": unknown.Available})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Model downloaded successfully"})
+	// Pulls run in the background, progress is listed in GET /models and
+	// reported by GET /models/load/:name
+	if model.Status == services.PullPulling {
+		c.JSON(http.StatusAccepted, gin.H{"message": "Model pull started", "model": model})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Model loaded successfully", "model": model})
 }
 
-func (h *Handler) LoadModel(c *gin.Context) {
+// GetModelLoad reports a pull started by LoadModel or ImportModel, including
+// how a recently finished one ended
+func (h *Handler) GetModelLoad(c *gin.Context) {
+	model, err := h.aiService.Pull(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"model": model})
+}
+
+func (h *Handler) CancelModelLoad(c *gin.Context) {
 	var req struct {
 		Name string `json:"name" binding:"required"`
 	}
@@ -77,12 +151,52 @@ func (h *Handler) LoadModel(c *gin.Context) {
 		return
 	}
 
-	if err := h.modelService.LoadModel(req.Name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.aiService.CancelPull(req.Name); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Model loaded successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Model pull cancelled"})
+}
+
+// ImportModel creates a backend model from a downloaded GGUF file. It runs
+// in the background like a pull, progress is listed in GET /models.
+func (h *Handler) ImportModel(c *gin.Context) {
+	var req types.ImportModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, modelfile, err := h.modelService.ImportModelfile(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrModelNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrModelUnavailable), errors.Is(err, services.ErrInvalidModelName),
+			errors.Is(err, services.ErrInvalidSystem):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	model, err := h.aiService.ImportModel(name, modelfile, func() {
+		if err := h.modelService.MarkImported(req.File, name); err != nil {
+			log.Printf("Warning: failed to record import of model %s: %v", req.File, err)
+		}
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   "Model import started",
+		"model":     model,
+		"modelfile": modelfile.String(),
+	})
 }
 
 func (h *Handler) DeleteModel(c *gin.Context) {
@@ -93,7 +207,14 @@ func (h *Handler) DeleteModel(c *gin.Context) {
 	}
 
 	if err := h.modelService.DeleteModel(name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, services.ErrModelNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrModelDownloading):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -119,16 +240,45 @@ func (h *Handler) UploadDocument(c *gin.Context) {
 
 	document, err := h.documentService.UploadDocument(file)
 	if err != nil {
+		var duplicate *services.DuplicateDocumentError
+		if errors.As(err, &duplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "document": duplicate.Existing})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "Document uploaded successfully",
+	if document.Duplicate {
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Document was already uploaded",
+			"document": document,
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  "Document uploaded, processing started",
 		"document": document,
 	})
 }
 
+func (h *Handler) GetDocument(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	document, err := h.documentService.GetDocument(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document": document})
+}
+
 func (h *Handler) DeleteDocument(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
@@ -170,42 +320,89 @@ func (h *Handler) Query(c *gin.Context) {
 		return
 	}
 
-	startTime := time.Now()
-
-	// Search documents if requested
-	var documents []types.Document
-	if req.IncludeDocuments {
-		docs, err := h.documentService.SearchDocuments(req.Query)
-		if err == nil {
-			documents = docs
-		}
+	model, ok := h.resolveModel(c, req.ModelName)
+	if !ok {
+		return
 	}
 
-	// Search wiki if requested
-	var wikiResults []types.WikiResult
-	if req.IncludeWiki {
-		wiki, err := h.wikiService.Search(req.Query)
-		if err == nil {
-			wikiResults = wiki
-		}
+	prompt, ok := h.resolvePrompt(c, req.Prompt, req.PromptVersion, req.Language)
+	if !ok {
+		return
 	}
 
+	startTime := time.Now()
+
+	rewrite := h.aiService.RewriteQuery(model, req.Query, nil, req.Rewrite, req.Paraphrases)
+	sources := h.retrieveSources(req, rewrite)
+
 	// Generate AI response
-	response, err := h.aiService.GenerateResponse(req.Query, documents, wikiResults)
+	answer, err := h.aiService.GenerateResponse(model, req.Query, sources.Chunks, sources.Wiki, req.Options, prompt)
 	if err != nil {
+		if errors.Is(err, services.ErrPromptTooLong) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	processingTime := time.Since(startTime).Seconds()
 
-	result := types.QueryResponse{
-		Response:       response,
-		ModelUsed:      h.aiService.GetCurrentModel(),
-		ProcessingTime: processingTime,
+	c.JSON(http.StatusOK, types.QueryResponse{
+		Response:         answer.Text,
+		Sources:          sources,
+		ModelUsed:        model,
+		ProcessingTime:   processingTime,
+		Citations:        answer.Citations,
+		InvalidCitations: answer.InvalidCitations,
+		DroppedSources:   answer.DroppedSources,
+		Rewrites:         rewrite,
+	})
+}
+
+// resolveModel picks the model for a request and writes an error response
+// if it isn't available.
+func (h *Handler) resolveModel(c *gin.Context, name string) (string, bool) {
+	model, err := h.aiService.ResolveModel(name)
+	if err != nil {
+		var unknown *services.UnknownModelError
+		if errors.As(err, &unknown) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "available": unknown.Available})
+			return "", false
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return model, true
+}
+
+// retrieveSources searches documents and Wikipedia as requested, for the
+// rewritten queries if there are any. Search failures leave the
+// corresponding source list empty.
+func (h *Handler) retrieveSources(req types.QueryRequest, rewrite *types.QueryRewrite) types.QuerySources {
+	var sources types.QuerySources
+
+	queries := []string{req.Query}
+	if rewrite != nil && len(rewrite.Searched) > 0 {
+		queries = rewrite.Searched
+	}
+
+	// Search documents if requested
+	if req.IncludeDocuments {
+		chunks, err := h.documentService.SearchDocumentVariants(queries, req.MaxSources)
+		if err == nil {
+			sources.Chunks = chunks
+			sources.Documents = h.documentService.DocumentsForChunks(chunks)
+		}
+	}
+
+	// Search wiki if requested
+	if req.IncludeWiki {
+		wiki, err := h.wikiService.SearchVariants(queries)
+		if err == nil {
+			sources.Wiki = wiki
+		}
 	}
-	result.Sources.Documents = documents
-	result.Sources.Wiki = wikiResults
 
-	c.JSON(http.StatusOK, result)
+	return sources
 }
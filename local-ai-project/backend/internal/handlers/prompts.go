@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"local-ai-project/backend/internal/services"
+	"local-ai-project/backend/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prompt template handlers
+func (h *Handler) ListPrompts(c *gin.Context) {
+	prompts, err := h.promptService.ListPrompts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prompts": prompts})
+}
+
+// GetPrompt returns the latest version of a template, or the one given by
+// the version query parameter
+func (h *Handler) GetPrompt(c *gin.Context) {
+	version := 0
+	if v := c.Query("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid prompt version"})
+			return
+		}
+	}
+
+	prompt, err := h.promptService.GetPrompt(c.Param("name"), version)
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prompt": prompt})
+}
+
+func (h *Handler) ListPromptVersions(c *gin.Context) {
+	versions, err := h.promptService.ListVersions(c.Param("name"))
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+func (h *Handler) CreatePrompt(c *gin.Context) {
+	var req types.PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, err := h.promptService.CreatePrompt(req)
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"prompt": prompt})
+}
+
+// UpdatePrompt saves a new version of a template
+func (h *Handler) UpdatePrompt(c *gin.Context) {
+	var req types.PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, err := h.promptService.UpdatePrompt(c.Param("name"), req)
+	if err != nil {
+		promptError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"prompt": prompt})
+}
+
+func (h *Handler) DeletePrompt(c *gin.Context) {
+	if err := h.promptService.DeletePrompt(c.Param("name")); err != nil {
+		promptError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt template deleted successfully"})
+}
+
+// resolvePrompt selects the prompt template for a request and writes an
+// error response if it doesn't exist.
+func (h *Handler) resolvePrompt(c *gin.Context, name string, version int, language string) (*services.Prompt, bool) {
+	prompt, err := h.promptService.Prompt(name, version, language)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptNotFound) || errors.Is(err, services.ErrInvalidPrompt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return prompt, true
+}
+
+func promptError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPromptNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPromptExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPrompt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
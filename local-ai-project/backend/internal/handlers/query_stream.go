@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"local-ai-project/backend/pkg/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryStream answers a query as Server-Sent Events: one "sources" event,
+// then "token" events as the model generates, then a "done" event with
+// timing and token counts. Failures after the stream started are sent as
+// an "error" event.
+func (h *Handler) QueryStream(c *gin.Context) {
+	var req types.QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	model, ok := h.resolveModel(c, req.ModelName)
+	if !ok {
+		return
+	}
+
+	prompt, ok := h.resolvePrompt(c, req.Prompt, req.PromptVersion, req.Language)
+	if !ok {
+		return
+	}
+
+	startTime := time.Now()
+
+	rewrite := h.aiService.RewriteQuery(model, req.Query, nil, req.Rewrite, req.Paraphrases)
+	sources := h.retrieveSources(req, rewrite)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	sendEvent := func(event string, data interface{}) error {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+		return c.Request.Context().Err()
+	}
+
+	if err := sendEvent("sources", sources); err != nil {
+		return
+	}
+
+	answer, err := h.aiService.StreamResponse(c.Request.Context(), model, req.Query, sources.Chunks, sources.Wiki, req.Options, prompt,
+		func(token string) error {
+			return sendEvent("token", gin.H{"token": token})
+		})
+	if err != nil {
+		// The client is gone, nobody is left to tell
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		sendEvent("error", gin.H{"error": err.Error()})
+		return
+	}
+
+	sendEvent("done", types.QueryStreamDone{
+		ModelUsed:        model,
+		ProcessingTime:   time.Since(startTime).Seconds(),
+		PromptTokens:     answer.Stats.PromptTokens,
+		CompletionTokens: answer.Stats.CompletionTokens,
+		Citations:        answer.Citations,
+		InvalidCitations: answer.InvalidCitations,
+		DroppedSources:   answer.DroppedSources,
+		Rewrites:         rewrite,
+	})
+}
@@ -1,115 +1,163 @@
 package services
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strings"
+	"context"
+	"errors"
+	"text/template"
 
 	"local-ai-project/backend/internal/config"
 	"local-ai-project/backend/pkg/types"
 )
 
+// ErrImportUnsupported is returned by ImportModel for backends that can't create models
+var ErrImportUnsupported = errors.New("model backend can't import model files")
+
 type AIService struct {
-	config       *config.Config
-	currentModel string
-	client       *http.Client
+	config  *config.Config
+	backend LLMBackend
+	models  *modelResolver
+	pulls   pullRegistry
 }
 
-func NewAIService(cfg *config.Config) *AIService {
+func NewAIService(cfg *config.Config, backend LLMBackend) *AIService {
 	return &AIService{
-		config: cfg,
-		client: &http.Client{},
+		config:  cfg,
+		backend: backend,
+		models:  newModelResolver(backend, cfg.DefaultModel),
 	}
 }
 
-func (s *AIService) LoadModel(modelName string) error {
-	// For Ollama, we can pull/load the model
-	reqBody := map[string]interface{}{
-		"name": modelName,
+// LoadModel makes a model the default for requests that don't name one.
+// Backends that can pull models start a background pull, which becomes the
+// default once it finishes. Other backends must already have the model.
+// onLoaded runs once the model is the default.
+func (s *AIService) LoadModel(modelName string, onLoaded func()) (types.Model, error) {
+	if puller, ok := s.backend.(ModelPuller); ok {
+		pull := func(ctx context.Context, onProgress func(PullProgress)) error {
+			return puller.Pull(ctx, modelName, onProgress)
+		}
+		return s.pulls.start(modelName, pull, func() {
+			s.models.setDefault(modelName)
+			s.models.installedModels(true)
+			onLoaded()
+		}), nil
 	}
 
-	jsonBody, _ := json.Marshal(reqBody)
-
-	resp, err := http.Post(s.config.OllamaURL+"/api/pull", "application/json",
-		bytes.NewReader(jsonBody))
+	resolved, err := s.models.resolve(modelName)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Ollama: %w", err)
+		return types.Model{}, err
 	}
-	defer resp.Body.Close()
+	s.models.setDefault(resolved)
+	onLoaded()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to load model: HTTP %d", resp.StatusCode)
-	}
-
-	s.currentModel = modelName
-	return nil
+	return types.Model{ID: resolved, Name: resolved, Status: PullReady, ModelType: "chat"}, nil
 }
 
-func (s *AIService) GenerateResponse(query string, documents []types.Document, wikiResults []types.WikiResult) (string, error) {
-	// Build context from documents and wiki results
-	var context strings.Builder
+// CancelPull stops a running model pull
+func (s *AIService) CancelPull(modelName string) error {
+	return s.pulls.cancel(modelName)
+}
 
-	// Add document context
-	if len(documents) > 0 {
-		context.WriteString("Relevant documents:\n")
-		for _, doc := range documents {
-			context.WriteString(fmt.Sprintf("- %s\n", doc.Name))
-		}
-		context.WriteString("\n")
+// ImportModel creates a model in the backend from a local GGUF file in the
+// background. The import is listed with the pulls and onDone runs once the
+// model can be used.
+func (s *AIService) ImportModel(name string, modelfile Modelfile, onDone func()) (types.Model, error) {
+	creator, ok := s.backend.(ModelCreator)
+	if !ok {
+		return types.Model{}, ErrImportUnsupported
 	}
 
-	// Add wiki context
-	if len(wikiResults) > 0 {
-		context.WriteString("Wikipedia information:\n")
-		for _, wiki := range wikiResults {
-			context.WriteString(fmt.Sprintf("- %s: %s\n", wiki.Title, wiki.Extract))
-		}
-		context.WriteString("\n")
+	create := func(ctx context.Context, onProgress func(PullProgress)) error {
+		return creator.CreateModel(ctx, name, modelfile, onProgress)
 	}
+	return s.pulls.start(name, create, func() {
+		s.models.installedModels(true)
+		onDone()
+	}), nil
+}
+
+// Pulls returns the model pulls in progress
+func (s *AIService) Pulls() []types.Model {
+	return s.pulls.list()
+}
 
-	// Create the prompt
-	prompt := fmt.Sprintf(`Based on the following context, please answer the question.
+// Pull returns the status of a running or recently finished pull or import
+func (s *AIService) Pull(modelName string) (types.Model, error) {
+	return s.pulls.get(modelName)
+}
 
-Context:
-%s
+// ResolveModel returns the model to use for a request. An empty name selects
+// the default model. Models the backend doesn't have return an *UnknownModelError.
+func (s *AIService) ResolveModel(name string) (string, error) {
+	return s.models.resolve(name)
+}
 
-Question: %s
+// defaultQueryPrompt is used when a query doesn't select a stored template
+var defaultQueryPrompt = template.Must(template.New("default").Parse(
+	`Answer the question using the numbered sources below. Cite the sources you
+use with their number in square brackets, like [1]. If the sources do not
+contain the answer, say so.{{if .Language}} Answer in {{.Language}}.{{end}}
+
+Sources:
+{{.Sources}}
+Question: {{.Question}}
+
+Answer:`))
+
+// Answer is a generated response with the citations found in it
+type Answer struct {
+	Text             string
+	Citations        []types.Citation
+	InvalidCitations []int                 // cited numbers without a source
+	DroppedSources   []types.DroppedSource // sources left out or shortened to fit the prompt
+	Stats            GenerationStats
+}
 
-Answer:`, context.String(), query)
+// GenerateResponse answers a query from the retrieved sources
+func (s *AIService) GenerateResponse(model, query string, chunks []types.DocumentChunk, wikiResults []types.WikiResult,
+	opts *types.GenerationOptions, prompt *Prompt) (*Answer, error) {
+	return s.answer(context.Background(), model, query, chunks, wikiResults, opts, prompt, nil)
+}
 
-	// Call Ollama API
-	reqBody := map[string]interface{}{
-		"model":  s.currentModel,
-		"prompt": prompt,
-		"stream": false,
-	}
+// StreamResponse generates a response and calls onToken for every piece of
+// text. Cancelling ctx stops the generation.
+func (s *AIService) StreamResponse(ctx context.Context, model, query string, chunks []types.DocumentChunk,
+	wikiResults []types.WikiResult, opts *types.GenerationOptions, prompt *Prompt,
+	onToken func(string) error) (*Answer, error) {
+	return s.answer(ctx, model, query, chunks, wikiResults, opts, prompt, onToken)
+}
 
-	jsonBody, _ := json.Marshal(reqBody)
+func (s *AIService) answer(ctx context.Context, model, query string, chunks []types.DocumentChunk,
+	wikiResults []types.WikiResult, opts *types.GenerationOptions, prompt *Prompt,
+	onToken func(string) error) (*Answer, error) {
+	options := generationOptions(s.config.Generation, opts)
 
-	resp, err := http.Post(s.config.OllamaURL+"/api/generate", "application/json",
-		bytes.NewReader(jsonBody))
+	rendered, sources, dropped, err := s.assemblePrompt(ctx, model, query, chunks, wikiResults, options, prompt)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate response: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("AI service error: HTTP %d", resp.StatusCode)
-	}
-
-	var response struct {
-		Response string `json:"response"`
+	text, stats, err := s.backend.Generate(ctx, model, rendered, options, onToken)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
+	citations, invalid := parseCitations(text, sources)
+	return &Answer{
+		Text:             text,
+		Citations:        citations,
+		InvalidCitations: invalid,
+		DroppedSources:   dropped,
+		Stats:            stats,
+	}, nil
+}
 
-	return response.Response, nil
+// Chat sends role-tagged messages to the model and returns the assistant's reply
+func (s *AIService) Chat(model string, messages []types.ChatMessage, opts *types.GenerationOptions) (string, GenerationStats, error) {
+	return s.backend.Chat(context.Background(), model, messages, generationOptions(s.config.Generation, opts), nil)
 }
 
+// GetCurrentModel returns the default model for requests that don't name one
 func (s *AIService) GetCurrentModel() string {
-	return s.currentModel
+	return s.models.getDefault()
 }
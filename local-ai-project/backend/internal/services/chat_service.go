@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+)
+
+// defaultChatPrompt is the system message of sessions that don't select a
+// stored template
+var defaultChatPrompt = template.Must(template.New("default-chat").Parse(
+	`You are a helpful assistant in a multi-turn conversation. Use the numbered
+sources below when they are relevant to the user's question and cite them
+with their number in square brackets, like [1]. If they do not contain the
+answer, say so.{{if .Language}} Answer in {{.Language}}.{{end}}
+{{- if .History}}
+
+Summary of the earlier conversation:
+{{.History}}{{end}}
+{{- if .Sources}}
+
+Sources:
+{{.Sources}}{{end}}`))
+
+type ChatService struct {
+	db     *sql.DB
+	config *config.Config
+	ai     *AIService
+}
+
+func NewChatService(db *sql.DB, cfg *config.Config, ai *AIService) *ChatService {
+	return &ChatService{db: db, config: cfg, ai: ai}
+}
+
+func (s *ChatService) CreateSession(title string) (*types.ChatSession, error) {
+	result, err := s.db.Exec(`INSERT INTO chat_sessions (title) VALUES (?)`, strings.TrimSpace(title))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat session: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return s.GetSession(int(id))
+}
+
+func (s *ChatService) ListSessions() ([]types.ChatSession, error) {
+	rows, err := s.db.Query(`SELECT id, title, created_at, updated_at FROM chat_sessions ORDER BY updated_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []types.ChatSession
+	for rows.Next() {
+		var session types.ChatSession
+		if err := rows.Scan(&session.ID, &session.Title, &session.CreatedAt, &session.UpdatedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetSession returns a session with all of its messages
+func (s *ChatService) GetSession(id int) (*types.ChatSession, error) {
+	var session types.ChatSession
+	err := s.db.QueryRow(`SELECT id, title, created_at, updated_at FROM chat_sessions WHERE id = ?`, id).
+		Scan(&session.ID, &session.Title, &session.CreatedAt, &session.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chat session with id %d not found", id)
+		}
+		return nil, err
+	}
+
+	session.Messages, err = s.messages(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *ChatService) DeleteSession(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM chat_messages WHERE session_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete chat messages: %w", err)
+	}
+
+	result, err := tx.Exec(`DELETE FROM chat_sessions WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete chat session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("chat session with id %d not found", id)
+	}
+
+	return tx.Commit()
+}
+
+// ChatReply is a stored assistant message with the citations found in it
+type ChatReply struct {
+	Message          *types.ChatMessage
+	Citations        []types.Citation
+	InvalidCitations []int
+	DroppedSources   []types.DroppedSource // sources left out or shortened to fit the prompt
+}
+
+// SendMessage answers a user message with the given model in the context of
+// the session's earlier turns and stores both messages.
+func (s *ChatService) SendMessage(sessionID int, model, message string, sources types.QuerySources,
+	opts *types.GenerationOptions, prompt *Prompt) (*ChatReply, error) {
+	var title, summary string
+	var summaryMessageID int
+	err := s.db.QueryRow(`SELECT title, summary, summary_message_id FROM chat_sessions WHERE id = ?`, sessionID).
+		Scan(&title, &summary, &summaryMessageID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("chat session with id %d not found", sessionID)
+		}
+		return nil, err
+	}
+
+	history, err := s.messages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the most recent turns that fit the history budget and fold
+	// older ones into the running summary
+	recent, older := splitHistory(history, s.config.ChatHistoryBudget)
+	var unsummarized []types.ChatMessage
+	for _, msg := range older {
+		if msg.ID > summaryMessageID {
+			unsummarized = append(unsummarized, msg)
+		}
+	}
+	if len(unsummarized) > 0 {
+		updated, err := s.summarize(model, summary, unsummarized)
+		if err != nil {
+			// Fall back to plain trimming
+			log.Printf("Warning: failed to summarize chat session %d: %v", sessionID, err)
+		} else {
+			summary = updated
+			summaryMessageID = unsummarized[len(unsummarized)-1].ID
+			if _, err := s.db.Exec(`UPDATE chat_sessions SET summary = ?, summary_message_id = ? WHERE id = ?`,
+				summary, summaryMessageID, sessionID); err != nil {
+				return nil, fmt.Errorf("failed to store chat summary: %w", err)
+			}
+		}
+	}
+
+	var conversation []types.ChatMessage
+	for _, msg := range recent {
+		conversation = append(conversation, types.ChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+	conversation = append(conversation, types.ChatMessage{Role: "user", Content: message})
+
+	system, cited, dropped, err := s.systemPrompt(model, summary, conversation, sources, opts, prompt)
+	if err != nil {
+		return nil, err
+	}
+	messages := append([]types.ChatMessage{{Role: "system", Content: system}}, conversation...)
+
+	reply, _, err := s.ai.Chat(model, messages, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.storeTurn(sessionID, title, message, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	citations, invalid := parseCitations(reply, cited)
+	return &ChatReply{Message: stored, Citations: citations, InvalidCitations: invalid, DroppedSources: dropped}, nil
+}
+
+// systemPrompt returns the system message with as many numbered sources as
+// fit the model's context window next to the conversation and the room
+// reserved for the answer. It also returns the included sources by number
+// and the ones left out or shortened. The question is not passed to the
+// template since it follows as the last user message.
+func (s *ChatService) systemPrompt(model, summary string, conversation []types.ChatMessage,
+	sources types.QuerySources, opts *types.GenerationOptions,
+	prompt *Prompt) (string, []types.Citation, []types.DroppedSource, error) {
+	ctx := context.Background()
+	counter := newTokenCounter(ctx, s.ai.backend, model)
+	vars := PromptVars{History: summary}
+
+	base, err := prompt.render(defaultChatPrompt, vars)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var turns strings.Builder
+	for _, msg := range conversation {
+		turns.WriteString(msg.Content + "\n")
+	}
+	budget, err := s.ai.sourceBudget(ctx, model, generationOptions(s.config.Generation, opts),
+		counter.count(base)+counter.count(turns.String()))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	included, cited, dropped := fitContext(counter, sources.Chunks, sources.Wiki, budget)
+	vars.Sources = included
+	system, err := prompt.render(defaultChatPrompt, vars)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return system, cited, dropped, nil
+}
+
+// summarize extends a running conversation summary with older turns
+func (s *ChatService) summarize(model, summary string, turns []types.ChatMessage) (string, error) {
+	var transcript strings.Builder
+	if summary != "" {
+		transcript.WriteString("Summary so far:\n" + summary + "\n\n")
+	}
+	transcript.WriteString("New turns:\n")
+	for _, turn := range turns {
+		transcript.WriteString(fmt.Sprintf("%s: %s\n", turn.Role, turn.Content))
+	}
+
+	reply, _, err := s.ai.Chat(model, []types.ChatMessage{
+		{Role: "system", Content: `Summarize the conversation below in a few sentences. Keep names, numbers,
+lists and any items the user may refer back to later. Reply with the summary only.`},
+		{Role: "user", Content: transcript.String()},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(reply), nil
+}
+
+func (s *ChatService) storeTurn(sessionID int, title, message, reply string) (*types.ChatMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO chat_messages (session_id, role, content) VALUES (?, 'user', ?)`,
+		sessionID, message); err != nil {
+		return nil, fmt.Errorf("failed to store message: %w", err)
+	}
+
+	result, err := tx.Exec(`INSERT INTO chat_messages (session_id, role, content) VALUES (?, 'assistant', ?)`,
+		sessionID, reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store reply: %w", err)
+	}
+	replyID, _ := result.LastInsertId()
+
+	// Untitled sessions are named after their first message
+	if title == "" {
+		title = truncateRunes(message, 60)
+	}
+	if _, err := tx.Exec(`UPDATE chat_sessions SET title = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		title, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to update chat session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	var stored types.ChatMessage
+	err = s.db.QueryRow(`SELECT id, role, content, created_at FROM chat_messages WHERE id = ?`, replyID).
+		Scan(&stored.ID, &stored.Role, &stored.Content, &stored.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+func (s *ChatService) messages(sessionID int) ([]types.ChatMessage, error) {
+	rows, err := s.db.Query(`SELECT id, role, content, created_at FROM chat_messages
+							 WHERE session_id = ? ORDER BY id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []types.ChatMessage
+	for rows.Next() {
+		var msg types.ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// splitHistory returns the newest messages that fit the token budget and
+// the older ones that don't.
+func splitHistory(history []types.ChatMessage, tokenBudget int) (recent, older []types.ChatMessage) {
+	used := 0
+	start := len(history)
+	for start > 0 {
+		tokens := estimateTokens(history[start-1].Content)
+		if used+tokens > tokenBudget {
+			break
+		}
+		used += tokens
+		start--
+	}
+
+	return history[start:], history[:start]
+}
+
+func truncateRunes(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// textChunk is a piece of a document's extracted text
+type textChunk struct {
+	Index   int
+	Content string
+	Offset  int // rune offset of the chunk start in the source text
+}
+
+// Preferred break points, strongest first. cut is the position inside sep
+// where the chunk ends.
+var chunkSeparators = []struct {
+	sep string
+	cut int
+}{
+	{"\n\n", 2},
+	{"\n", 1},
+	{". ", 2},
+	{"! ", 2},
+	{"? ", 2},
+	{"; ", 2},
+	{" ", 1},
+}
+
+// Markdown headings (from .md files and converted DOCX) start a new section
+const headingSeparator = "\n#"
+
+// chunkText splits text into chunks of at most size runes, where each chunk
+// repeats the last overlap runes of the previous one.
+func chunkText(text string, size, overlap int) []textChunk {
+	if size <= 0 {
+		size = 1000
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	runes := []rune(text)
+	var chunks []textChunk
+
+	start := 0
+	for start < len(runes) {
+		// Skip leading whitespace
+		for start < len(runes) && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		if start >= len(runes) {
+			break
+		}
+
+		end := start + size
+		sectionBreak := false
+		if end >= len(runes) {
+			end = len(runes)
+		} else {
+			end, sectionBreak = findChunkBreak(runes, start, end)
+		}
+
+		content := strings.TrimSpace(string(runes[start:end]))
+		if content != "" {
+			chunks = append(chunks, textChunk{
+				Index:   len(chunks),
+				Content: content,
+				Offset:  start,
+			})
+		}
+
+		if end >= len(runes) {
+			break
+		}
+
+		// Step back for the overlap, but always make progress. A new
+		// section starts cleanly at its heading.
+		next := end - overlap
+		if next <= start || sectionBreak {
+			next = end
+		}
+		// Don't start the next chunk in the middle of a word
+		for next < end && next > 0 && !unicode.IsSpace(runes[next-1]) {
+			next++
+		}
+		start = next
+	}
+
+	return chunks
+}
+
+// findChunkBreak returns the best position in runes[start:end] to end a chunk
+// and whether that position is the start of a new section. Headings are
+// searched in the last three quarters of the window, other separators in the
+// second half so chunks don't get too small.
+func findChunkBreak(runes []rune, start, end int) (int, bool) {
+	headingMin := start + (end-start)/4
+	window := string(runes[headingMin:end])
+	if i := strings.LastIndex(window, headingSeparator); i >= 0 {
+		return headingMin + utf8.RuneCountInString(window[:i+1]), true
+	}
+
+	minEnd := start + (end-start)/2
+	window = string(runes[minEnd:end])
+	for _, s := range chunkSeparators {
+		if i := strings.LastIndex(window, s.sep); i >= 0 {
+			return minEnd + utf8.RuneCountInString(window[:i+s.cut]), false
+		}
+	}
+
+	return end, false
+}
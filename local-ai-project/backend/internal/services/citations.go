@@ -0,0 +1,117 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// citationMarker matches [1], [1, 3] and [1-3]
+var citationMarker = regexp.MustCompile(`\[(\d+(?:\s*[,-]\s*\d+)*)\]`)
+
+// parseCitations finds the [n] markers in a response and links each to the
+// numbered source it cites and the sentence it supports. Numbers without a
+// source in the prompt are returned as invalid.
+func parseCitations(response string, sources []types.Citation) ([]types.Citation, []int) {
+	citations := []types.Citation{}
+	invalid := map[int]bool{}
+
+	for _, match := range citationMarker.FindAllStringSubmatchIndex(response, -1) {
+		start, end := citedSpan(response, match[0])
+		text := strings.TrimSpace(citationMarker.ReplaceAllString(response[start:end], ""))
+
+		for _, number := range citationNumbers(response[match[2]:match[3]]) {
+			if number < 1 || number > len(sources) {
+				invalid[number] = true
+				continue
+			}
+
+			citation := sources[number-1]
+			citation.Number = number
+			citation.Start = utf8.RuneCountInString(response[:start])
+			citation.End = citation.Start + utf8.RuneCountInString(response[start:end])
+			citation.Text = text
+			citations = append(citations, citation)
+		}
+	}
+
+	var invalidNumbers []int
+	for number := range invalid {
+		invalidNumbers = append(invalidNumbers, number)
+	}
+	sort.Ints(invalidNumbers)
+
+	return citations, invalidNumbers
+}
+
+// citationNumbers expands the inside of a marker, "1, 3-4" gives 1, 3, 4
+func citationNumbers(list string) []int {
+	var numbers []int
+	for _, part := range strings.Split(list, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			continue
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || last < first || last-first > 20 {
+				last = first
+			}
+		}
+		for n := first; n <= last; n++ {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// citedSpan returns the byte range of the sentence a marker at pos belongs
+// to. A marker at the start of a sentence cites the sentence before it.
+func citedSpan(text string, pos int) (int, int) {
+	// Back up over the sentence end and other markers right before pos
+	end := pos
+	for end > 0 {
+		trimmed := strings.TrimRightFunc(text[:end], unicode.IsSpace)
+		if loc := citationMarker.FindStringIndex(trimmed); loc != nil && loc[1] == len(trimmed) {
+			end = loc[0]
+			continue
+		}
+		end = len(trimmed)
+		break
+	}
+
+	start := end
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if r == '\n' {
+			break
+		}
+		// Sentence ends are followed by a space, unlike the dot in 3.5
+		if strings.ContainsRune(".!?", r) && start < end {
+			next, _ := utf8.DecodeRuneInString(text[start:])
+			if unicode.IsSpace(next) || next == '[' {
+				break
+			}
+		}
+		start -= size
+	}
+
+	// Skip the whitespace and markers the previous sentence ended with
+	for {
+		trimmed := strings.TrimLeftFunc(text[start:end], unicode.IsSpace)
+		start = end - len(trimmed)
+		if loc := citationMarker.FindStringIndex(trimmed); loc != nil && loc[0] == 0 {
+			start += loc[1]
+			continue
+		}
+		break
+	}
+
+	return start, end
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+func TestCitationNumbers(t *testing.T) {
+	tests := map[string][]int{
+		"1":        {1},
+		"1, 3":     {1, 3},
+		"1-3":      {1, 2, 3},
+		"1 - 3, 5": {1, 2, 3, 5},
+		"3-1":      {3}, // reversed range
+		"1-30":     {1}, // too long to be a real range
+		"":         nil,
+	}
+
+	for list, want := range tests {
+		if got := citationNumbers(list); !reflect.DeepEqual(got, want) {
+			t.Errorf("citationNumbers(%q) = %v, want %v", list, got, want)
+		}
+	}
+}
+
+func TestCitedSpan(t *testing.T) {
+	tests := []struct {
+		text   string
+		marker string // first occurrence is the cited marker
+		want   string
+	}{
+		{"Go is fast [1]. It compiles quickly [2].", "[1]", "Go is fast"},
+		{"Go is fast [1]. It compiles quickly [2].", "[2]", "It compiles quickly"},
+		{"Go is fast. [1] It compiles quickly.", "[1]", "Go is fast."},
+		{"Version 3.5 is out [1].", "[1]", "Version 3.5 is out"},
+		{"Go is fast [1][2].", "[2]", "Go is fast"},
+		{"A is true.[1] B is true [2].", "[2]", "B is true"},
+		{"Is Go fast? Yes [1].", "[1]", "Yes"},
+		{"Sources:\n- Go is fast [1]", "[1]", "- Go is fast"},
+		{"[1] Go is fast.", "[1]", ""},
+	}
+
+	for _, tt := range tests {
+		start, end := citedSpan(tt.text, strings.Index(tt.text, tt.marker))
+		if got := tt.text[start:end]; got != tt.want {
+			t.Errorf("citedSpan(%q) at %s = %q, want %q", tt.text, tt.marker, got, tt.want)
+		}
+	}
+}
+
+func TestParseCitations(t *testing.T) {
+	doc := types.Citation{SourceType: "document", Name: "a.pdf", DocumentID: 1}
+	wiki := types.Citation{SourceType: "wiki", Name: "Go"}
+
+	// cited returns source as cited by the rune range [start, end) of text
+	cited := func(source types.Citation, number, start, end int, text string) types.Citation {
+		source.Number, source.Start, source.End, source.Text = number, start, end, text
+		return source
+	}
+
+	citations, invalid := parseCitations("Gö is fast [1]. Tests pass [1-2]! Nothing here [0, 5].",
+		[]types.Citation{doc, wiki})
+
+	want := []types.Citation{
+		cited(doc, 1, 0, 10, "Gö is fast"),
+		cited(doc, 1, 16, 26, "Tests pass"),
+		cited(wiki, 2, 16, 26, "Tests pass"),
+	}
+	if !reflect.DeepEqual(citations, want) {
+		t.Errorf("citations = %+v, want %+v", citations, want)
+	}
+	if !reflect.DeepEqual(invalid, []int{0, 5}) {
+		t.Errorf("invalid = %v, want [0 5]", invalid)
+	}
+
+	if citations, invalid := parseCitations("No markers.", nil); len(citations) != 0 || invalid != nil {
+		t.Errorf("parseCitations without markers = %v, %v", citations, invalid)
+	}
+}
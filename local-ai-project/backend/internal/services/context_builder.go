@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// contextBlock is one labelled source in the prompt context
+type contextBlock struct {
+	Label  string
+	Text   string
+	Source types.Citation // what a citation of this block refers to
+}
+
+// contextBlocks lists the retrieved sources in prompt order, documents
+// before wiki, each best-ranked first.
+func contextBlocks(chunks []types.DocumentChunk, wikiResults []types.WikiResult) []contextBlock {
+	var blocks []contextBlock
+	for _, chunk := range chunks {
+		chunkIndex := chunk.ChunkIndex
+		blocks = append(blocks, contextBlock{
+			Label: chunkLabel(chunk),
+			Text:  chunk.Content,
+			Source: types.Citation{
+				SourceType: "document",
+				Name:       chunk.DocumentName,
+				DocumentID: chunk.DocumentID,
+				ChunkIndex: &chunkIndex,
+				Page:       chunk.Page,
+			},
+		})
+	}
+	for _, wiki := range wikiResults {
+		if wiki.Extract == "" {
+			continue
+		}
+		blocks = append(blocks, contextBlock{
+			Label:  "Wikipedia: " + wiki.Title,
+			Text:   wiki.Extract,
+			Source: types.Citation{SourceType: "wiki", Name: wiki.Title, URL: wiki.URL},
+		})
+	}
+	return blocks
+}
+
+func chunkLabel(chunk types.DocumentChunk) string {
+	if chunk.Page > 0 {
+		return fmt.Sprintf("%s, page %d", chunk.DocumentName, chunk.Page)
+	}
+	return chunk.DocumentName
+}
+
+func formatContextBlock(number int, block contextBlock) string {
+	return fmt.Sprintf("[%d] Source: %s\n%s\n\n", number, block.Label, strings.TrimSpace(block.Text))
+}
+
+// estimateTokens approximates the token count at four characters per token
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
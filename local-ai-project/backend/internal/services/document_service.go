@@ -1,30 +1,70 @@
 package services
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
 	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/internal/storage"
 	"local-ai-project/backend/pkg/types"
 )
 
 type DocumentService struct {
-	db     *sql.DB
-	config *config.Config
+	db       *sql.DB
+	config   *config.Config
+	embedder *EmbeddingService
+	fullText bool
+	jobs     chan int64
+
+	dedupePolicy string
+}
+
+func NewDocumentService(db *sql.DB, cfg *config.Config, embedder *EmbeddingService) *DocumentService {
+	return &DocumentService{
+		db:       db,
+		config:   cfg,
+		embedder: embedder,
+		fullText: storage.FullTextSearchEnabled(db),
+		jobs:     make(chan int64, 100),
+
+		dedupePolicy: dedupePolicy(cfg.DedupePolicy),
+	}
 }
 
-func NewDocumentService(db *sql.DB, cfg *config.Config) *DocumentService {
-	return &DocumentService{db: db, config: cfg}
+// documentSelect reads a document together with its chunk statistics
+const documentSelect = `SELECT d.id, d.original_name, d.size, d.type, d.created_at,
+				COALESCE(d.status, 'ready'), COALESCE(d.error_message, ''), COALESCE(d.progress, 0),
+				COALESCE(d.content_hash, ''), COALESCE(d.version, 1),
+				(SELECT COUNT(*) FROM document_chunks c WHERE c.document_id = d.id),
+				EXISTS (SELECT 1 FROM document_chunks c WHERE c.document_id = d.id AND c.embedding IS NOT NULL)
+			  FROM documents d`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDocument(row rowScanner) (types.Document, error) {
+	var doc types.Document
+	var createdAt string
+	err := row.Scan(&doc.ID, &doc.Name, &doc.Size, &doc.Type, &createdAt,
+		&doc.Status, &doc.Error, &doc.Progress, &doc.ContentHash, &doc.Version, &doc.Chunks, &doc.Embeddings)
+	doc.UploadDate = createdAt
+	return doc, err
 }
 
 func (s *DocumentService) ListDocuments() ([]types.Document, error) {
-	query := `SELECT id, filename, original_name, size, type, created_at FROM documents ORDER BY created_at DESC`
-	rows, err := s.db.Query(query)
+	rows, err := s.db.Query(documentSelect + ` ORDER BY d.created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -32,20 +72,55 @@ func (s *DocumentService) ListDocuments() ([]types.Document, error) {
 
 	var documents []types.Document
 	for rows.Next() {
-		var doc types.Document
-		var createdAt string
-		err := rows.Scan(&doc.ID, &doc.Name, &doc.Name, &doc.Size, &doc.Type, &createdAt)
+		doc, err := scanDocument(rows)
 		if err != nil {
 			return nil, err
 		}
-		doc.UploadDate = createdAt
-		doc.Status = "ready"
 		documents = append(documents, doc)
 	}
 
 	return documents, nil
 }
 
+func (s *DocumentService) GetDocument(id int) (*types.Document, error) {
+	doc, err := scanDocument(s.db.QueryRow(documentSelect+` WHERE d.id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("document with id %d not found", id)
+		}
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Policies for uploads whose content matches an existing document
+const (
+	DedupeReject   = "reject"
+	DedupeExisting = "existing"
+	DedupeReplace  = "replace"
+)
+
+// dedupePolicy validates the configured policy, falling back to returning
+// the existing document so a typo doesn't silently change behavior
+func dedupePolicy(policy string) string {
+	switch policy {
+	case DedupeReject, DedupeExisting, DedupeReplace:
+		return policy
+	}
+	log.Printf("Warning: unknown DEDUPE_POLICY %q (use reject, existing or replace), using %q", policy, DedupeExisting)
+	return DedupeExisting
+}
+
+// DuplicateDocumentError is returned for duplicate uploads under the
+// reject policy.
+type DuplicateDocumentError struct {
+	Existing *types.Document
+}
+
+func (e *DuplicateDocumentError) Error() string {
+	return fmt.Sprintf("document already uploaded as %q (id %d)", e.Existing.Name, e.Existing.ID)
+}
+
 func (s *DocumentService) UploadDocument(fileHeader *multipart.FileHeader) (*types.Document, error) {
 	// Create uploads directory if it doesn't exist
 	if err := os.MkdirAll(s.config.UploadsPath, 0755); err != nil {
@@ -59,96 +134,387 @@ func (s *DocumentService) UploadDocument(fileHeader *multipart.FileHeader) (*typ
 	}
 	defer file.Close()
 
-	// Create unique filename
-	filename := fmt.Sprintf("%d_%s", time.Now().Unix(), fileHeader.Filename)
-	filePath := filepath.Join(s.config.UploadsPath, filename)
-
-	// Create the destination file
-	dst, err := os.Create(filePath)
+	// Write to a temporary file first; it only gets its final name once
+	// we know the upload isn't a duplicate
+	dst, err := os.CreateTemp(s.config.UploadsPath, ".upload-*")
 	if err != nil {
 		return nil, err
 	}
-	defer dst.Close()
+	tmpPath := dst.Name()
+	defer os.Remove(tmpPath)
 
-	// Copy file content
-	if _, err = io.Copy(dst, file); err != nil {
+	// Copy file content, hashing it on the way
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hasher), file)
+	dst.Close()
+	if err != nil {
 		return nil, err
 	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
 
-	// Extract text content
-	content, err := s.extractTextContent(filePath, fileHeader.Filename)
+	// Handle exact duplicates according to the configured policy
+	version := 1
+	existing, err := s.findByHash(contentHash)
 	if err != nil {
-		content = "" // Continue even if text extraction fails
+		return nil, err
+	}
+	if existing != nil {
+		if s.dedupePolicy != DedupeReplace {
+			return s.duplicate(existing)
+		}
+		version = existing.Version + 1
 	}
 
-	// Save to database
-	query := `INSERT INTO documents (filename, original_name, path, size, type, content) 
-			  VALUES (?, ?, ?, ?, ?, ?)`
+	// Create unique filename
+	filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), fileHeader.Filename)
+	filePath := filepath.Join(s.config.UploadsPath, filename)
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return nil, err
+	}
+
+	// Save to database. Extraction, chunking and embedding run in the
+	// background so large files don't block the request.
+	query := `INSERT INTO documents (filename, original_name, path, size, type, status, progress, content_hash, version) 
+			  VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)`
 
 	result, err := s.db.Exec(query, filename, fileHeader.Filename, filePath, fileHeader.Size,
-		filepath.Ext(fileHeader.Filename), content)
+		filepath.Ext(fileHeader.Filename), DocumentQueued, contentHash, version)
 	if err != nil {
+		os.Remove(filePath)
+		if storage.IsUniqueViolation(err) {
+			// A concurrent upload of the same content was inserted first
+			if existing, findErr := s.findByHash(contentHash); findErr == nil && existing != nil {
+				return s.duplicate(existing)
+			}
+		}
 		return nil, err
 	}
 
 	id, _ := result.LastInsertId()
 
+	s.enqueue(id)
+
 	return &types.Document{
-		ID:         int(id),
-		Name:       fileHeader.Filename,
-		Type:       filepath.Ext(fileHeader.Filename),
-		Size:       fileHeader.Size,
-		UploadDate: time.Now().Format("2006-01-02 15:04:05"),
-		Status:     "ready",
+		ID:          int(id),
+		Name:        fileHeader.Filename,
+		Type:        filepath.Ext(fileHeader.Filename),
+		Size:        fileHeader.Size,
+		UploadDate:  time.Now().Format("2006-01-02 15:04:05"),
+		Status:      DocumentQueued,
+		ContentHash: contentHash,
+		Version:     version,
 	}, nil
 }
 
-func (s *DocumentService) extractTextContent(filePath, originalName string) (string, error) {
-	ext := filepath.Ext(originalName)
+// duplicate answers an upload of already stored content according to the
+// dedupe policy. Under replace this is only reached when a concurrent
+// upload already stored the same content as the newest version.
+func (s *DocumentService) duplicate(existing *types.Document) (*types.Document, error) {
+	if s.dedupePolicy == DedupeReject {
+		return nil, &DuplicateDocumentError{Existing: existing}
+	}
+	existing.Duplicate = true
+	return existing, nil
+}
+
+// latestVersion limits a query over documents d to the current version of
+// their content. Earlier versions are kept, but once a newer version is
+// ready they no longer show up in retrieval.
+const latestVersion = `NOT EXISTS (SELECT 1 FROM documents n WHERE n.content_hash = d.content_hash
+				AND n.version > d.version AND n.status = 'ready')`
+
+// findByHash returns the newest usable document with the given content
+// hash, or nil. Failed documents don't count, so re-uploading retries them.
+func (s *DocumentService) findByHash(contentHash string) (*types.Document, error) {
+	doc, err := scanDocument(s.db.QueryRow(documentSelect+` WHERE d.content_hash = ? AND d.status != ?
+		ORDER BY d.version DESC, d.id DESC LIMIT 1`, contentHash, DocumentFailed))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// extractedText is the plain text of a document and where each page starts
+type extractedText struct {
+	Content string
+	Pages   []int // rune offsets of page starts, empty for unpaged formats
+}
+
+// pageAt returns the 1-based page containing the rune offset, or 0 when
+// the document has no page information.
+func (e *extractedText) pageAt(offset int) int {
+	page := 0
+	for i, start := range e.Pages {
+		if start > offset {
+			break
+		}
+		page = i + 1
+	}
+	return page
+}
+
+func (s *DocumentService) extractTextContent(filePath, originalName string) (*extractedText, error) {
+	ext := strings.ToLower(filepath.Ext(originalName))
 
 	switch ext {
 	case ".txt", ".md":
 		content, err := os.ReadFile(filePath)
-		return string(content), err
+		if err != nil {
+			return nil, err
+		}
+		return &extractedText{Content: string(content)}, nil
 	case ".pdf":
-		// TODO: Implement PDF text extraction
-		return "PDF text extraction not implemented yet", nil
+		return extractPDFText(filePath)
 	case ".docx":
-		// TODO: Implement DOCX text extraction
-		return "DOCX text extraction not implemented yet", nil
+		return extractDOCXText(filePath)
 	default:
-		return "", fmt.Errorf("unsupported file type: %s", ext)
+		return nil, fmt.Errorf("unsupported file type: %s", ext)
+	}
+}
+
+const (
+	defaultMaxSources = 5
+	maxSourcesLimit   = 20
+)
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultMaxSources
+	}
+	return min(limit, maxSourcesLimit)
+}
+
+// SearchDocuments runs keyword and vector search and merges both candidate
+// lists with reciprocal rank fusion. Either list may be empty, e.g. when
+// Ollama is unreachable or no chunk has an embedding yet.
+func (s *DocumentService) SearchDocuments(query string, limit int) ([]types.DocumentChunk, error) {
+	return s.SearchDocumentVariants([]string{query}, limit)
+}
+
+// SearchDocumentVariants searches for several phrasings of the same
+// question and fuses the candidate lists of all of them, so a chunk found
+// by more variants ranks higher and appears only once.
+func (s *DocumentService) SearchDocumentVariants(queries []string, limit int) ([]types.DocumentChunk, error) {
+	limit = clampLimit(limit)
+	candidates := max(s.config.HybridCandidates, limit)
+
+	var lists []rankedList
+
+	vectorHits, err := s.vectorCandidates(queries, candidates)
+	if err != nil {
+		log.Printf("Warning: vector search failed, using keyword search only: %v", err)
+	}
+	for _, hits := range vectorHits {
+		lists = append(lists, rankedList{hits: hits, weight: s.config.HybridVectorWeight, vector: true})
+	}
+
+	for _, query := range queries {
+		keywordHits, err := s.keywordCandidates(query, candidates)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, rankedList{hits: keywordHits, weight: s.config.HybridKeywordWeight})
+	}
+
+	fused := reciprocalRankFusion(s.config.HybridRRFK, lists...)
+	if len(fused) > limit {
+		fused = fused[:limit]
 	}
+
+	return s.loadChunks(fused)
 }
 
-func (s *DocumentService) SearchDocuments(query string) ([]types.Document, error) {
-	// Simple text search in content
-	sqlQuery := `SELECT id, filename, original_name, size, type, content, created_at 
-				 FROM documents 
-				 WHERE content LIKE ? 
-				 ORDER BY created_at DESC LIMIT 5`
+// KeywordSearch ranks chunks by BM25 over the FTS5 index and highlights the
+// matching terms. Without the index it falls back to substring matching.
+func (s *DocumentService) KeywordSearch(query string, limit int) ([]types.DocumentChunk, error) {
+	hits, err := s.keywordCandidates(query, clampLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	return s.loadChunks(hits)
+}
 
-	rows, err := s.db.Query(sqlQuery, "%"+query+"%")
+// vectorCandidates returns the nearest chunks for each query, embedding
+// all queries in one request
+func (s *DocumentService) vectorCandidates(queries []string, limit int) ([][]scoredChunk, error) {
+	queryVectors, err := s.embedder.Embed(queries)
+	if err != nil {
+		return nil, err
+	}
+	if len(queryVectors) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT c.id, c.embedding FROM document_chunks c
+							 JOIN documents d ON d.id = c.document_id
+							 WHERE c.embedding IS NOT NULL AND c.embedding_model = ? AND c.embedding_dim = ?
+							 AND `+latestVersion,
+		s.embedder.Model(), len(queryVectors[0]))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var documents []types.Document
+	scored := make([][]scoredChunk, len(queryVectors))
 	for rows.Next() {
-		var doc types.Document
-		var filename, content, createdAt string
-		err := rows.Scan(&doc.ID, &filename, &doc.Name, &doc.Size,
-			&doc.Type, &content, &createdAt)
-		if err != nil {
+		var id int64
+		var blob []byte
+		if err := rows.Scan(&id, &blob); err != nil {
 			return nil, err
 		}
-		doc.UploadDate = createdAt
-		doc.Status = "ready"
-		documents = append(documents, doc)
+		embedding := decodeEmbedding(blob)
+		for i, queryVector := range queryVectors {
+			scored[i] = append(scored[i], scoredChunk{
+				id:    id,
+				score: cosineSimilarity(queryVector, embedding),
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return documents, nil
+	for i := range scored {
+		sort.Slice(scored[i], func(a, b int) bool { return scored[i][a].score > scored[i][b].score })
+		if len(scored[i]) > limit {
+			scored[i] = scored[i][:limit]
+		}
+	}
+
+	return scored, nil
+}
+
+func (s *DocumentService) keywordCandidates(query string, limit int) ([]scoredChunk, error) {
+	if !s.fullText {
+		return s.substringCandidates(query, limit)
+	}
+
+	match := ftsMatchQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(`SELECT document_chunks_fts.rowid, bm25(document_chunks_fts),
+								snippet(document_chunks_fts, 0, '<mark>', '</mark>', '…', 24)
+							 FROM document_chunks_fts
+							 JOIN document_chunks c ON c.id = document_chunks_fts.rowid
+							 JOIN documents d ON d.id = c.document_id
+							 WHERE document_chunks_fts MATCH ? AND `+latestVersion+`
+							 ORDER BY bm25(document_chunks_fts) LIMIT ?`, match, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scored []scoredChunk
+	for rows.Next() {
+		var hit scoredChunk
+		var rank float64
+		if err := rows.Scan(&hit.id, &rank, &hit.highlight); err != nil {
+			return nil, err
+		}
+		// bm25() is lower for better matches
+		hit.score = -rank
+		scored = append(scored, hit)
+	}
+
+	return scored, rows.Err()
+}
+
+// ftsMatchQuery turns free text into an FTS5 query that matches any of the
+// terms. Each term is quoted so user input can't inject FTS5 syntax.
+func ftsMatchQuery(query string) string {
+	var terms []string
+	for _, field := range strings.Fields(query) {
+		term := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if term == "" {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// substringCandidates is the keyword fallback when FTS5 is not available
+func (s *DocumentService) substringCandidates(query string, limit int) ([]scoredChunk, error) {
+	rows, err := s.db.Query(`SELECT c.id FROM document_chunks c
+							 JOIN documents d ON d.id = c.document_id
+							 WHERE c.content LIKE ? AND `+latestVersion+`
+							 ORDER BY d.created_at DESC, c.chunk_index LIMIT ?`, "%"+query+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scored []scoredChunk
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		scored = append(scored, scoredChunk{id: id})
+	}
+
+	return scored, rows.Err()
+}
+
+// DocumentsForChunks returns the parent documents of chunks, in order of
+// first appearance.
+func (s *DocumentService) DocumentsForChunks(chunks []types.DocumentChunk) []types.Document {
+	var documents []types.Document
+	seen := map[int]bool{}
+
+	for _, chunk := range chunks {
+		if seen[chunk.DocumentID] {
+			continue
+		}
+		seen[chunk.DocumentID] = true
+
+		if doc, err := s.GetDocument(chunk.DocumentID); err == nil {
+			documents = append(documents, *doc)
+		}
+	}
+
+	return documents
+}
+
+// scoredChunk is a search hit before its content is loaded
+type scoredChunk struct {
+	id          int64
+	score       float64
+	highlight   string
+	vectorRank  int
+	keywordRank int
+}
+
+// loadChunks fetches the content of scored chunks, keeping their order
+func (s *DocumentService) loadChunks(scored []scoredChunk) ([]types.DocumentChunk, error) {
+	chunks := make([]types.DocumentChunk, 0, len(scored))
+
+	for _, hit := range scored {
+		var chunk types.DocumentChunk
+		var page sql.NullInt64
+		err := s.db.QueryRow(`SELECT c.document_id, d.original_name, c.chunk_index, c.page, c.content
+							  FROM document_chunks c JOIN documents d ON d.id = c.document_id
+							  WHERE c.id = ?`, hit.id).
+			Scan(&chunk.DocumentID, &chunk.DocumentName, &chunk.ChunkIndex, &page, &chunk.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunk %d: %w", hit.id, err)
+		}
+		chunk.Page = int(page.Int64)
+		chunk.Score = hit.score
+		chunk.Highlight = hit.highlight
+		chunk.VectorRank = hit.vectorRank
+		chunk.KeywordRank = hit.keywordRank
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
 }
 
 func (s *DocumentService) DeleteDocument(id int) error {
@@ -169,6 +535,11 @@ func (s *DocumentService) DeleteDocument(id int) error {
 		return fmt.Errorf("failed to get document path: %w", err)
 	}
 
+	// Delete chunks before the document they reference
+	if _, err := tx.Exec("DELETE FROM document_chunks WHERE document_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete document chunks: %w", err)
+	}
+
 	// Delete from database first
 	result, err := tx.Exec("DELETE FROM documents WHERE id = ?", id)
 	if err != nil {
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var headingStyleName = regexp.MustCompile(`(?i)^heading\s*(\d)$`)
+
+// extractDOCXText converts word/document.xml into markdown-like text:
+// headings become "#" lines, list items "- " lines and tables pipe rows.
+func extractDOCXText(filePath string) (*extractedText, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DOCX: %w", err)
+	}
+	defer archive.Close()
+
+	var documentFile, stylesFile *zip.File
+	for _, f := range archive.File {
+		switch f.Name {
+		case "word/document.xml":
+			documentFile = f
+		case "word/styles.xml":
+			stylesFile = f
+		}
+	}
+	if documentFile == nil {
+		return nil, fmt.Errorf("invalid DOCX: word/document.xml not found")
+	}
+
+	headings := map[string]int{}
+	if stylesFile != nil {
+		if headings, err = readDOCXHeadingStyles(stylesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := documentFile.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DOCX body: %w", err)
+	}
+	defer rc.Close()
+
+	content, err := convertDOCXBody(rc, headings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DOCX body: %w", err)
+	}
+
+	return &extractedText{Content: content}, nil
+}
+
+// readDOCXHeadingStyles maps style IDs to heading levels. Style IDs are
+// localized ("Heading1", "Überschrift1", ...), so the level comes from the
+// style's outline level or its built-in English name.
+func readDOCXHeadingStyles(f *zip.File) (map[string]int, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DOCX styles: %w", err)
+	}
+	defer rc.Close()
+
+	var styles struct {
+		Styles []struct {
+			ID   string `xml:"styleId,attr"`
+			Name struct {
+				Val string `xml:"val,attr"`
+			} `xml:"name"`
+			OutlineLevel *struct {
+				Val string `xml:"val,attr"`
+			} `xml:"pPr>outlineLvl"`
+		} `xml:"style"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&styles); err != nil {
+		return nil, fmt.Errorf("failed to parse DOCX styles: %w", err)
+	}
+
+	levels := map[string]int{}
+	for _, style := range styles.Styles {
+		switch {
+		case style.OutlineLevel != nil:
+			if lvl, err := strconv.Atoi(style.OutlineLevel.Val); err == nil && lvl < 9 {
+				levels[style.ID] = lvl + 1
+			}
+		case strings.EqualFold(style.Name.Val, "title"):
+			levels[style.ID] = 1
+		default:
+			if m := headingStyleName.FindStringSubmatch(style.Name.Val); m != nil {
+				levels[style.ID], _ = strconv.Atoi(m[1])
+			}
+		}
+	}
+
+	return levels, nil
+}
+
+// docxParagraph collects the state of the paragraph being decoded
+type docxParagraph struct {
+	text      strings.Builder
+	heading   int
+	listLevel int // -1 when the paragraph is not a list item
+}
+
+func convertDOCXBody(r io.Reader, headings map[string]int) (string, error) {
+	decoder := xml.NewDecoder(r)
+
+	var out strings.Builder
+	var para *docxParagraph
+	var outer []*docxParagraph // paragraphs containing a text box with paragraphs of its own
+	var row []string
+	var cell *strings.Builder
+	rowsInTable := 0
+	tableDepth := 0
+	inText := false
+	inList := false
+	runDepth := 0 // tab elements outside runs define tab stops
+
+	writeParagraph := func(p *docxParagraph) {
+		text := strings.TrimSpace(p.text.String())
+		if text == "" {
+			return
+		}
+		// Paragraphs inside a table (including nested tables) join the cell text
+		if cell != nil {
+			if cell.Len() > 0 {
+				cell.WriteString(" ")
+			}
+			cell.WriteString(strings.ReplaceAll(text, "\n", " "))
+			return
+		}
+
+		// Close a list with a blank line before the next block
+		isListItem := p.listLevel >= 0 && p.heading == 0
+		if inList && !isListItem {
+			out.WriteString("\n")
+		}
+		inList = isListItem
+
+		switch {
+		case p.heading > 0:
+			out.WriteString(strings.Repeat("#", p.heading) + " " + text + "\n\n")
+		case isListItem:
+			out.WriteString(strings.Repeat("  ", p.listLevel) + "- " + text + "\n")
+		default:
+			out.WriteString(text + "\n\n")
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				if para != nil {
+					outer = append(outer, para)
+				}
+				para = &docxParagraph{listLevel: -1}
+			case "pStyle":
+				if para != nil {
+					para.heading = headings[docxAttr(t, "val")]
+				}
+			case "outlineLvl":
+				if para != nil {
+					if lvl, err := strconv.Atoi(docxAttr(t, "val")); err == nil && lvl < 9 {
+						para.heading = lvl + 1
+					}
+				}
+			case "numPr":
+				if para != nil && para.listLevel < 0 {
+					para.listLevel = 0
+				}
+			case "ilvl":
+				if para != nil {
+					if lvl, err := strconv.Atoi(docxAttr(t, "val")); err == nil {
+						para.listLevel = lvl
+					}
+				}
+			case "r":
+				runDepth++
+			case "t":
+				inText = true
+			case "tab":
+				if para != nil && runDepth > 0 {
+					para.text.WriteString("\t")
+				}
+			case "br", "cr":
+				if para != nil {
+					para.text.WriteString("\n")
+				}
+			case "tbl":
+				tableDepth++
+				if tableDepth == 1 {
+					rowsInTable = 0
+					if inList {
+						out.WriteString("\n")
+						inList = false
+					}
+				}
+			case "tr":
+				if tableDepth == 1 {
+					row = nil
+				}
+			case "tc":
+				if tableDepth == 1 {
+					cell = &strings.Builder{}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "r":
+				runDepth--
+			case "t":
+				inText = false
+			case "p":
+				if para != nil {
+					writeParagraph(para)
+					para = nil
+				}
+				if len(outer) > 0 {
+					para = outer[len(outer)-1]
+					outer = outer[:len(outer)-1]
+				}
+			case "tc":
+				if tableDepth == 1 && cell != nil {
+					row = append(row, strings.ReplaceAll(cell.String(), "|", "\\|"))
+					cell = nil
+				}
+			case "tr":
+				if tableDepth == 1 && len(row) > 0 {
+					out.WriteString("| " + strings.Join(row, " | ") + " |\n")
+					if rowsInTable == 0 {
+						out.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+					}
+					rowsInTable++
+				}
+			case "tbl":
+				tableDepth--
+				if tableDepth == 0 {
+					out.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if inText && para != nil {
+				para.text.Write(t)
+			}
+		}
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+func docxAttr(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"local-ai-project/backend/internal/config"
+)
+
+type EmbeddingService struct {
+	config  *config.Config
+	backend LLMBackend
+}
+
+func NewEmbeddingService(cfg *config.Config, backend LLMBackend) *EmbeddingService {
+	return &EmbeddingService{
+		config:  cfg,
+		backend: backend,
+	}
+}
+
+// Model returns the name of the model used for embeddings
+func (s *EmbeddingService) Model() string {
+	return s.config.EmbeddingModel
+}
+
+// Embed returns one vector per input text, calling the backend in batches
+func (s *EmbeddingService) Embed(texts []string) ([][]float32, error) {
+	batchSize := s.config.EmbeddingBatchSize
+	if batchSize <= 0 {
+		batchSize = 16
+	}
+
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+
+		batch, err := s.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("embedding service returned %d vectors for %d inputs", len(batch), end-start)
+		}
+		vectors = append(vectors, batch...)
+	}
+
+	return vectors, nil
+}
+
+func (s *EmbeddingService) embedBatch(texts []string) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return s.backend.Embed(ctx, s.config.EmbeddingModel, texts)
+}
+
+// encodeEmbedding stores a vector as little-endian float32 values
+func encodeEmbedding(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+func decodeEmbedding(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+)
+
+// defaultNumPredict is the answer length in tokens when neither the request
+// nor GEN_NUM_PREDICT sets one
+const defaultNumPredict = 512
+
+// generationOptions merges request options over the configured defaults and
+// clamps them to the configured limits. Unset fields other than num_predict
+// stay nil so the backend uses the model's own defaults.
+func generationOptions(cfg config.GenerationConfig, req *types.GenerationOptions) types.GenerationOptions {
+	if req == nil {
+		req = &types.GenerationOptions{}
+	}
+
+	var options types.GenerationOptions
+
+	if v := pick(req.Temperature, cfg.Temperature); v != nil {
+		options.Temperature = ptr(clampFloat(*v, 0, cfg.MaxTemperature))
+	}
+	if v := pick(req.TopK, cfg.TopK); v != nil {
+		options.TopK = ptr(clampInt(*v, 1, cfg.MaxTopK))
+	}
+	if v := pick(req.TopP, cfg.TopP); v != nil {
+		options.TopP = ptr(clampFloat(*v, 0, 1))
+	}
+	if v := pick(req.RepeatPenalty, cfg.RepeatPenalty); v != nil {
+		options.RepeatPenalty = ptr(clampFloat(*v, 0, 2))
+	}
+	if v := pick(req.NumCtx, cfg.NumCtx); v != nil {
+		options.NumCtx = ptr(clampInt(*v, 512, cfg.MaxNumCtx))
+	}
+
+	// Ollama treats a non-positive num_predict as unlimited, which other
+	// backends don't support, so those values get the default as well
+	numPredict := defaultNumPredict
+	if v := pick(req.NumPredict, cfg.NumPredict); v != nil && *v > 0 {
+		numPredict = *v
+	}
+	options.NumPredict = ptr(clampInt(numPredict, 1, cfg.MaxNumPredict))
+
+	options.Seed = pick(req.Seed, cfg.Seed)
+
+	for _, s := range req.Stop {
+		if s == "" {
+			continue
+		}
+		options.Stop = append(options.Stop, s)
+	}
+	if cfg.MaxStop >= 0 && len(options.Stop) > cfg.MaxStop {
+		options.Stop = options.Stop[:cfg.MaxStop]
+	}
+
+	return options
+}
+
+// pick returns the request value if set, otherwise the default
+func pick[T any](requested, fallback *T) *T {
+	if requested != nil {
+		return requested
+	}
+	return fallback
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// clampFloat limits v to [lo, hi]. A non-positive hi means no upper limit.
+func clampFloat(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if hi > 0 && v > hi {
+		return hi
+	}
+	return v
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if hi > 0 && v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ErrNotGGUF is returned for files that don't have a valid GGUF header
+var ErrNotGGUF = errors.New("not a GGUF model file")
+
+// Limits that keep a corrupt header from allocating or looping forever
+const (
+	maxGGUFCount     = 1 << 24
+	maxGGUFStringLen = 1 << 24
+	maxGGUFDims      = 8
+)
+
+// GGUF metadata value types
+const (
+	ggufUint8 uint32 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufFileTypes names general.file_type, the quantization of most tensors
+var ggufFileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0", 37: "TQ2_0",
+}
+
+// Architectures that only produce embeddings
+var embeddingArchitectures = map[string]bool{
+	"bert": true, "nomic-bert": true, "nomic-bert-moe": true, "jina-bert-v2": true,
+	"t5encoder": true, "modern-bert": true, "neo-bert": true,
+}
+
+// ggufInfo is the model metadata read from a GGUF header
+type ggufInfo struct {
+	Architecture    string
+	Name            string
+	ParameterCount  int64
+	Quantization    string
+	ContextLength   int
+	EmbeddingLength int
+	ChatTemplate    string
+	Embedding       bool // embedding-only model
+}
+
+// readGGUFFile reads the metadata of a GGUF file. Only the header is read,
+// not the tensor data.
+func readGGUFFile(path string) (*ggufInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseGGUF(bufio.NewReaderSize(f, 1<<20))
+}
+
+// ggufReader decodes little-endian GGUF values. Version 1 files use 32-bit
+// lengths and counts, later versions 64-bit.
+type ggufReader struct {
+	r       *bufio.Reader
+	version uint32
+	err     error
+}
+
+func (g *ggufReader) read(v interface{}) {
+	if g.err == nil {
+		g.err = binary.Read(g.r, binary.LittleEndian, v)
+	}
+}
+
+func (g *ggufReader) u32() uint32 {
+	var v uint32
+	g.read(&v)
+	return v
+}
+
+func (g *ggufReader) u64() uint64 {
+	var v uint64
+	g.read(&v)
+	return v
+}
+
+// count reads a length or count, which is 32-bit in version 1
+func (g *ggufReader) count(limit uint64) uint64 {
+	var n uint64
+	if g.version == 1 {
+		n = uint64(g.u32())
+	} else {
+		n = g.u64()
+	}
+	if g.err == nil && n > limit {
+		g.err = fmt.Errorf("%w: count %d out of range", ErrNotGGUF, n)
+	}
+	return n
+}
+
+func (g *ggufReader) str() string {
+	n := g.count(maxGGUFStringLen)
+	if g.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	_, g.err = io.ReadFull(g.r, buf)
+	return string(buf)
+}
+
+func (g *ggufReader) skip(n uint64) {
+	if g.err == nil {
+		_, g.err = g.r.Discard(int(n))
+	}
+}
+
+// value reads a metadata value. Numbers come back as uint64, int64 or
+// float64 and arrays are skipped, nothing this package shows needs them.
+func (g *ggufReader) value(valueType uint32) interface{} {
+	switch valueType {
+	case ggufUint8, ggufInt8, ggufBool:
+		var v uint8
+		g.read(&v)
+		if valueType == ggufInt8 {
+			return int64(int8(v))
+		}
+		return uint64(v)
+	case ggufUint16, ggufInt16:
+		var v uint16
+		g.read(&v)
+		if valueType == ggufInt16 {
+			return int64(int16(v))
+		}
+		return uint64(v)
+	case ggufUint32, ggufInt32:
+		v := g.u32()
+		if valueType == ggufInt32 {
+			return int64(int32(v))
+		}
+		return uint64(v)
+	case ggufUint64, ggufInt64:
+		v := g.u64()
+		if valueType == ggufInt64 {
+			return int64(v)
+		}
+		return v
+	case ggufFloat32:
+		return float64(math.Float32frombits(g.u32()))
+	case ggufFloat64:
+		return math.Float64frombits(g.u64())
+	case ggufString:
+		return g.str()
+	case ggufArray:
+		itemType := g.u32()
+		n := g.count(maxGGUFCount)
+		g.skipArray(itemType, n)
+		return nil
+	default:
+		if g.err == nil {
+			g.err = fmt.Errorf("%w: unknown value type %d", ErrNotGGUF, valueType)
+		}
+		return nil
+	}
+}
+
+func (g *ggufReader) skipArray(itemType uint32, n uint64) {
+	sizes := map[uint32]uint64{
+		ggufUint8: 1, ggufInt8: 1, ggufBool: 1, ggufUint16: 2, ggufInt16: 2,
+		ggufUint32: 4, ggufInt32: 4, ggufFloat32: 4, ggufUint64: 8, ggufInt64: 8, ggufFloat64: 8,
+	}
+	if size, ok := sizes[itemType]; ok {
+		g.skip(n * size)
+		return
+	}
+
+	// Strings and nested arrays have to be walked item by item
+	for i := uint64(0); i < n && g.err == nil; i++ {
+		if itemType == ggufString {
+			g.skip(g.count(maxGGUFStringLen))
+		} else {
+			g.value(itemType)
+		}
+	}
+}
+
+func parseGGUF(r *bufio.Reader) (*ggufInfo, error) {
+	g := &ggufReader{r: r}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != "GGUF" {
+		return nil, ErrNotGGUF
+	}
+	g.version = g.u32()
+	if g.err == nil && (g.version < 1 || g.version > 3) {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotGGUF, g.version)
+	}
+
+	tensorCount := g.count(maxGGUFCount)
+	kvCount := g.count(maxGGUFCount)
+
+	metadata := make(map[string]interface{})
+	for i := uint64(0); i < kvCount && g.err == nil; i++ {
+		key := g.str()
+		metadata[key] = g.value(g.u32())
+	}
+
+	// The parameter count is the number of weights in all tensors
+	var parameters int64
+	for i := uint64(0); i < tensorCount && g.err == nil; i++ {
+		g.skip(g.count(maxGGUFStringLen)) // name
+		dims := g.u32()
+		if g.err == nil && dims > maxGGUFDims {
+			g.err = fmt.Errorf("%w: tensor with %d dimensions", ErrNotGGUF, dims)
+		}
+		elements := int64(1)
+		for d := uint32(0); d < dims && g.err == nil; d++ {
+			elements *= int64(g.u64())
+		}
+		g.u32() // tensor type
+		g.u64() // data offset
+		parameters += elements
+	}
+
+	if g.err != nil {
+		if errors.Is(g.err, ErrNotGGUF) {
+			return nil, g.err
+		}
+		return nil, fmt.Errorf("%w: truncated header: %v", ErrNotGGUF, g.err)
+	}
+
+	info := &ggufInfo{ParameterCount: parameters}
+	info.Architecture, _ = metadata["general.architecture"].(string)
+	info.Name, _ = metadata["general.name"].(string)
+	info.ChatTemplate, _ = metadata["tokenizer.chat_template"].(string)
+	if fileType, ok := metadata["general.file_type"].(uint64); ok {
+		info.Quantization = ggufFileTypes[fileType]
+	}
+	info.ContextLength = int(ggufInt(metadata[info.Architecture+".context_length"]))
+	info.EmbeddingLength = int(ggufInt(metadata[info.Architecture+".embedding_length"]))
+
+	// Pooling turns token states into one vector, a pooling type other
+	// than none (0) marks embedding models of otherwise generative architectures
+	info.Embedding = embeddingArchitectures[info.Architecture] ||
+		ggufInt(metadata[info.Architecture+".pooling_type"]) > 0
+
+	return info, nil
+}
+
+func ggufInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case uint64:
+		return int64(n)
+	case int64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// ggufCount is a length or count, 32-bit in version 1 files and 64-bit after
+type ggufCount uint64
+
+// ggufHeader encodes a GGUF header of the given version. Strings get their
+// length prefix, everything else is written as is in little-endian.
+func ggufHeader(version uint32, values ...interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("GGUF")
+	binary.Write(&buf, binary.LittleEndian, version)
+
+	count := func(n uint64) {
+		if version == 1 {
+			binary.Write(&buf, binary.LittleEndian, uint32(n))
+		} else {
+			binary.Write(&buf, binary.LittleEndian, n)
+		}
+	}
+	for _, v := range values {
+		switch v := v.(type) {
+		case ggufCount:
+			count(uint64(v))
+		case string:
+			count(uint64(len(v)))
+			buf.WriteString(v)
+		default:
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+func parseGGUFBytes(data []byte) (*ggufInfo, error) {
+	return parseGGUF(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func TestParseGGUF(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   ggufInfo
+	}{
+		{
+			name: "version 3 chat model",
+			header: ggufHeader(3, ggufCount(2), ggufCount(5),
+				"general.architecture", ggufString, "llama",
+				"general.name", ggufString, "Tiny Llama",
+				"general.file_type", ggufUint32, uint32(15),
+				"llama.context_length", ggufUint32, uint32(4096),
+				"tokenizer.chat_template", ggufString, "<|start_header_id|>",
+				// name, dimensions, sizes, tensor type, data offset
+				"token_embd.weight", uint32(2), uint64(64), uint64(100), uint32(0), uint64(0),
+				"output_norm.weight", uint32(1), uint64(64), uint32(0), uint64(0),
+			),
+			want: ggufInfo{
+				Architecture:   "llama",
+				Name:           "Tiny Llama",
+				ParameterCount: 6464,
+				Quantization:   "Q4_K_M",
+				ContextLength:  4096,
+				ChatTemplate:   "<|start_header_id|>",
+			},
+		},
+		{
+			name: "version 1 has 32-bit counts",
+			header: ggufHeader(1, ggufCount(1), ggufCount(2),
+				"general.architecture", ggufString, "llama",
+				"llama.embedding_length", ggufUint64, uint64(64),
+				"token_embd.weight", uint32(2), uint64(64), uint64(10), uint32(0), uint64(0),
+			),
+			want: ggufInfo{Architecture: "llama", EmbeddingLength: 64, ParameterCount: 640},
+		},
+		{
+			name: "arrays are skipped",
+			header: ggufHeader(3, ggufCount(0), ggufCount(4),
+				"tokenizer.ggml.tokens", ggufArray, ggufString, ggufCount(2), "a", "bc",
+				"tokenizer.ggml.scores", ggufArray, ggufFloat32, ggufCount(2), float32(1), float32(2),
+				"nested", ggufArray, ggufArray, ggufCount(2),
+				ggufUint16, ggufCount(2), uint16(1), uint16(2),
+				ggufString, ggufCount(1), "x",
+				"general.architecture", ggufString, "qwen2",
+			),
+			want: ggufInfo{Architecture: "qwen2"},
+		},
+		{
+			name: "unknown file type",
+			header: ggufHeader(2, ggufCount(0), ggufCount(1),
+				"general.file_type", ggufUint32, uint32(999),
+			),
+			want: ggufInfo{},
+		},
+		{
+			name: "embedding architecture",
+			header: ggufHeader(3, ggufCount(0), ggufCount(1),
+				"general.architecture", ggufString, "nomic-bert",
+			),
+			want: ggufInfo{Architecture: "nomic-bert", Embedding: true},
+		},
+		{
+			name: "pooling marks an embedding model",
+			header: ggufHeader(3, ggufCount(0), ggufCount(2),
+				"general.architecture", ggufString, "qwen2",
+				"qwen2.pooling_type", ggufInt32, int32(1),
+			),
+			want: ggufInfo{Architecture: "qwen2", Embedding: true},
+		},
+		{
+			name: "no pooling",
+			header: ggufHeader(3, ggufCount(0), ggufCount(2),
+				"general.architecture", ggufString, "qwen2",
+				"qwen2.pooling_type", ggufInt32, int32(0),
+			),
+			want: ggufInfo{Architecture: "qwen2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGGUFBytes(tt.header)
+			if err != nil {
+				t.Fatalf("parseGGUF: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGGUFRejectsBadHeaders(t *testing.T) {
+	valid := ggufHeader(3, ggufCount(0), ggufCount(1), "general.architecture", ggufString, "llama")
+
+	headers := map[string][]byte{
+		"empty":               nil,
+		"bad magic":           append([]byte("GGML"), valid[4:]...),
+		"no version":          valid[:4],
+		"version 0":           ggufHeader(0, ggufCount(0), ggufCount(0)),
+		"version 4":           ggufHeader(4, ggufCount(0), ggufCount(0)),
+		"truncated counts":    valid[:12],
+		"truncated value":     valid[:len(valid)-2],
+		"missing metadata":    ggufHeader(3, ggufCount(0), ggufCount(1)),
+		"too many keys":       ggufHeader(3, ggufCount(0), ggufCount(maxGGUFCount+1)),
+		"string too long":     ggufHeader(3, ggufCount(0), ggufCount(1), ggufCount(maxGGUFStringLen+1)),
+		"unknown value type":  ggufHeader(3, ggufCount(0), ggufCount(1), "key", uint32(99)),
+		"too many dimensions": ggufHeader(3, ggufCount(1), ggufCount(0), "t", uint32(maxGGUFDims+1)),
+	}
+
+	for name, header := range headers {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseGGUFBytes(header); !errors.Is(err, ErrNotGGUF) {
+				t.Errorf("got error %v, want ErrNotGGUF", err)
+			}
+		})
+	}
+}
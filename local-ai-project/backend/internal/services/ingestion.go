@@ -0,0 +1,306 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+)
+
+// Document processing states
+const (
+	DocumentQueued     = "queued"
+	DocumentExtracting = "extracting"
+	DocumentEmbedding  = "embedding"
+	DocumentReady      = "ready"
+	DocumentFailed     = "failed"
+)
+
+// StartIngestion starts the background workers that process uploaded
+// documents and re-queues documents left unfinished by a previous run.
+func (s *DocumentService) StartIngestion() error {
+	s.backfillContentHashes()
+
+	workers := s.config.IngestWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go s.ingestionWorker()
+	}
+
+	rows, err := s.db.Query(`SELECT id FROM documents WHERE status IN (?, ?, ?) ORDER BY id`,
+		DocumentQueued, DocumentExtracting, DocumentEmbedding)
+	if err != nil {
+		return fmt.Errorf("failed to find pending documents: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		pending = append(pending, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The backlog can be larger than the queue, so startup doesn't wait for it
+	go func() {
+		for _, id := range pending {
+			s.enqueue(id)
+		}
+	}()
+	if len(pending) > 0 {
+		log.Printf("Resuming processing of %d document(s)", len(pending))
+	}
+
+	return nil
+}
+
+// backfillContentHashes hashes documents uploaded before deduplication
+// existed, so re-uploads of them are detected too.
+func (s *DocumentService) backfillContentHashes() {
+	rows, err := s.db.Query(`SELECT id, path FROM documents WHERE content_hash IS NULL`)
+	if err != nil {
+		log.Printf("Warning: failed to find unhashed documents: %v", err)
+		return
+	}
+
+	paths := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var path string
+		if err := rows.Scan(&id, &path); err == nil {
+			paths[id] = path
+		}
+	}
+	rows.Close()
+
+	for id, path := range paths {
+		contentHash, err := hashFile(path)
+		if err != nil {
+			log.Printf("Warning: failed to hash document %d: %v", id, err)
+			continue
+		}
+		if _, err := s.db.Exec(`UPDATE documents SET content_hash = ? WHERE id = ?`, contentHash, id); err != nil {
+			log.Printf("Warning: failed to store hash of document %d: %v", id, err)
+		}
+	}
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// enqueue schedules a document for processing. It blocks while the queue
+// is full, which holds uploads back until the workers catch up.
+func (s *DocumentService) enqueue(id int64) {
+	s.jobs <- id
+}
+
+func (s *DocumentService) ingestionWorker() {
+	for id := range s.jobs {
+		if err := s.processDocument(id); err != nil {
+			log.Printf("Document %d failed: %v", id, err)
+			s.setStatus(id, DocumentFailed, 0, err.Error())
+		}
+	}
+}
+
+// processDocument extracts, chunks and embeds one document. A panic fails
+// the document instead of taking the server down with the worker.
+func (s *DocumentService) processDocument(id int64) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Document %d panicked: %v\n%s", id, r, debug.Stack())
+			err = fmt.Errorf("processing failed: %v", r)
+		}
+	}()
+
+	var filePath, originalName string
+	err = s.db.QueryRow("SELECT path, original_name FROM documents WHERE id = ?", id).
+		Scan(&filePath, &originalName)
+	if err == sql.ErrNoRows {
+		return nil // deleted while queued
+	}
+	if err != nil {
+		return err
+	}
+
+	s.setStatus(id, DocumentExtracting, 5, "")
+
+	extracted, err := s.extractTextContent(filePath, originalName)
+	if err != nil {
+		return err
+	}
+
+	// Split the extracted text into overlapping chunks for retrieval
+	chunks := chunkText(extracted.Content, s.config.ChunkSize, s.config.ChunkOverlap)
+	if err := s.storeDocumentText(id, extracted, chunks); err != nil {
+		return err
+	}
+
+	s.setStatus(id, DocumentEmbedding, 30, "")
+
+	// Embeddings are optional: without them the document is still
+	// searchable by keyword, so a failure only leaves a warning
+	warning := ""
+	if err := s.embedDocumentChunks(id); err != nil {
+		log.Printf("Warning: failed to embed document %d: %v", id, err)
+		warning = "embeddings unavailable: " + err.Error()
+	}
+
+	s.setStatus(id, DocumentReady, 100, warning)
+	return nil
+}
+
+func (s *DocumentService) setStatus(id int64, status string, progress float64, message string) {
+	_, err := s.db.Exec(`UPDATE documents SET status = ?, progress = ?, error_message = ? WHERE id = ?`,
+		status, progress, message, id)
+	if err != nil {
+		log.Printf("Warning: failed to update status of document %d: %v", id, err)
+	}
+}
+
+func (s *DocumentService) setProgress(id int64, progress float64) {
+	if _, err := s.db.Exec(`UPDATE documents SET progress = ? WHERE id = ?`, progress, id); err != nil {
+		log.Printf("Warning: failed to update progress of document %d: %v", id, err)
+	}
+}
+
+// storeDocumentText replaces the content and chunks of a document, so a
+// resumed job doesn't duplicate chunks.
+func (s *DocumentService) storeDocumentText(id int64, extracted *extractedText, chunks []textChunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE documents SET content = ? WHERE id = ?", extracted.Content, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("document with id %d not found", id)
+	}
+
+	if _, err := tx.Exec("DELETE FROM document_chunks WHERE document_id = ?", id); err != nil {
+		return fmt.Errorf("failed to clear old chunks: %w", err)
+	}
+
+	if err := s.storeChunks(tx, id, chunks, extracted); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DocumentService) storeChunks(tx *sql.Tx, documentID int64, chunks []textChunk, extracted *extractedText) error {
+	stmt, err := tx.Prepare(`INSERT INTO document_chunks (document_id, content, chunk_index, page) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare chunk insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, chunk := range chunks {
+		var page interface{}
+		if p := extracted.pageAt(chunk.Offset); p > 0 {
+			page = p
+		}
+		if _, err := stmt.Exec(documentID, chunk.Content, chunk.Index, page); err != nil {
+			return fmt.Errorf("failed to store chunk %d: %w", chunk.Index, err)
+		}
+	}
+
+	return nil
+}
+
+// embedDocumentChunks computes embeddings for all chunks of a document
+// that don't have one yet, one batch at a time so progress is visible.
+func (s *DocumentService) embedDocumentChunks(documentID int64) error {
+	rows, err := s.db.Query(`SELECT id, content FROM document_chunks
+							 WHERE document_id = ? AND embedding IS NULL ORDER BY chunk_index`, documentID)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	var texts []string
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		texts = append(texts, content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	batchSize := max(s.config.EmbeddingBatchSize, 1)
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+
+		vectors, err := s.embedder.Embed(texts[start:end])
+		if err != nil {
+			return err
+		}
+		if err := s.storeEmbeddings(ids[start:end], vectors); err != nil {
+			return err
+		}
+
+		// Embedding covers 30% to 100% of the progress
+		s.setProgress(documentID, 30+70*float64(end)/float64(len(texts)))
+	}
+
+	return nil
+}
+
+func (s *DocumentService) storeEmbeddings(ids []int64, vectors [][]float32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE document_chunks SET embedding = ?, embedding_model = ?, embedding_dim = ? WHERE id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare embedding update: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, vector := range vectors {
+		if _, err := stmt.Exec(encodeEmbedding(vector), s.embedder.Model(), len(vector), ids[i]); err != nil {
+			return fmt.Errorf("failed to store embedding: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,236 @@
+//go:build llama
+
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+
+	llama "github.com/go-skynet/go-llama.cpp"
+)
+
+// llamaMaxTokenBytes bounds the length in bytes of one generated token
+const llamaMaxTokenBytes = 128
+
+// llamaBackend runs GGUF models from the models directory in-process with
+// llama.cpp. It needs the go-llama.cpp binding library, see the README.
+type llamaBackend struct {
+	config *config.Config
+
+	mu     sync.Mutex
+	loaded map[string]*llamaModel
+}
+
+// llamaModel is a loaded model. llama.cpp contexts can't run two
+// predictions at once, so requests for the same model take turns.
+type llamaModel struct {
+	mu sync.Mutex
+	ll *llama.LLama
+}
+
+func newLlamaBackend(cfg *config.Config) (LLMBackend, error) {
+	if _, err := os.Stat(cfg.ModelsPath); err != nil {
+		return nil, fmt.Errorf("models directory not available: %w", err)
+	}
+	return &llamaBackend{config: cfg, loaded: make(map[string]*llamaModel)}, nil
+}
+
+// model loads a model on first use and keeps it in memory
+func (b *llamaBackend) model(name string) (*llamaModel, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if m, ok := b.loaded[name]; ok {
+		return m, nil
+	}
+
+	path, err := b.modelPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ll, err := llama.New(path,
+		llama.SetContext(b.config.LlamaContextSize),
+		llama.SetGPULayers(b.config.LlamaGPULayers),
+		llama.EnableF16Memory,
+		llama.EnableEmbeddings,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load model %s: %w", name, err)
+	}
+
+	m := &llamaModel{ll: ll}
+	b.loaded[name] = m
+	return m, nil
+}
+
+// modelPath finds the file for a model name, with or without extension
+func (b *llamaBackend) modelPath(name string) (string, error) {
+	if name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid model name %s", name)
+	}
+
+	for _, candidate := range []string{name, name + ".gguf", name + ".bin"} {
+		path := filepath.Join(b.config.ModelsPath, candidate)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("model %s not found", name)
+}
+
+func (b *llamaBackend) Generate(ctx context.Context, model, prompt string, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	var stats GenerationStats
+
+	m, err := b.model(model)
+	if err != nil {
+		return "", stats, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The binding uses its token limit as the size in bytes of the buffer it
+	// copies the answer into, so it gets room for the longest tokens and the
+	// real limit is enforced here
+	maxTokens := b.config.LlamaContextSize
+	if opts.NumPredict != nil && *opts.NumPredict > 0 {
+		maxTokens = *opts.NumPredict
+	}
+
+	var callbackErr error
+	predictOpts := append(llamaPredictOptions(b.config, opts),
+		llama.SetTokens(maxTokens*llamaMaxTokenBytes),
+		llama.SetTokenCallback(func(token string) bool {
+			stats.CompletionTokens++
+			if onToken != nil {
+				if err := onToken(token); err != nil {
+					callbackErr = err
+					return false
+				}
+			}
+			return ctx.Err() == nil && stats.CompletionTokens < maxTokens
+		}))
+
+	text, err := m.ll.Predict(prompt, predictOpts...)
+	if callbackErr != nil {
+		return "", stats, callbackErr
+	}
+	if err := ctx.Err(); err != nil {
+		return "", stats, err
+	}
+	if err != nil {
+		return "", stats, fmt.Errorf("failed to generate response: %w", err)
+	}
+
+	count, _, err := m.ll.TokenizeString(prompt, llama.SetTokens(b.config.LlamaContextSize))
+	if err != nil {
+		log.Printf("Warning: failed to count prompt tokens for %s: %v", model, err)
+	}
+	stats.PromptTokens = int(count)
+
+	return text, stats, nil
+}
+
+func (b *llamaBackend) Chat(ctx context.Context, model string, messages []types.ChatMessage, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	// Keep the model from writing the user's next turn
+	opts.Stop = append(opts.Stop, "### User:")
+	return b.Generate(ctx, model, chatPrompt(messages), opts, onToken)
+}
+
+func (b *llamaBackend) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	m, err := b.model(model)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		vector, err := m.ll.Embeddings(text, llama.SetThreads(b.config.LlamaThreads))
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed text: %w", err)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+func (b *llamaBackend) CountTokens(ctx context.Context, model, text string) (int, error) {
+	m, err := b.model(model)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// TokenizeString writes into a buffer of this many tokens
+	count, _, err := m.ll.TokenizeString(text, llama.SetTokens(b.config.LlamaContextSize))
+	if err != nil {
+		return 0, fmt.Errorf("failed to tokenize: %w", err)
+	}
+	return int(count), nil
+}
+
+// ContextWindow is the context size models are loaded with
+func (b *llamaBackend) ContextWindow(ctx context.Context, model string) (int, error) {
+	return b.config.LlamaContextSize, nil
+}
+
+// ListModels returns the GGUF files in the models directory
+func (b *llamaBackend) ListModels(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(b.config.ModelsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read models directory: %w", err)
+	}
+
+	var models []string
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if !file.IsDir() && (ext == ".gguf" || ext == ".bin") {
+			models = append(models, file.Name())
+		}
+	}
+	return models, nil
+}
+
+func llamaPredictOptions(cfg *config.Config, opts types.GenerationOptions) []llama.PredictOption {
+	predictOpts := []llama.PredictOption{llama.SetThreads(cfg.LlamaThreads)}
+
+	if opts.Temperature != nil {
+		predictOpts = append(predictOpts, llama.SetTemperature(float32(*opts.Temperature)))
+	}
+	if opts.TopK != nil {
+		predictOpts = append(predictOpts, llama.SetTopK(*opts.TopK))
+	}
+	if opts.TopP != nil {
+		predictOpts = append(predictOpts, llama.SetTopP(float32(*opts.TopP)))
+	}
+	if opts.RepeatPenalty != nil {
+		predictOpts = append(predictOpts, llama.SetPenalty(float32(*opts.RepeatPenalty)))
+	}
+	if opts.Seed != nil {
+		predictOpts = append(predictOpts, llama.SetSeed(*opts.Seed))
+	}
+	if len(opts.Stop) > 0 {
+		predictOpts = append(predictOpts, llama.SetStopWords(opts.Stop...))
+	}
+
+	return predictOpts
+}
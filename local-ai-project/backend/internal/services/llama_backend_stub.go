@@ -0,0 +1,15 @@
+//go:build !llama
+
+package services
+
+import (
+	"fmt"
+
+	"local-ai-project/backend/internal/config"
+)
+
+// The in-process backend needs cgo and the llama.cpp binding library, so it
+// is only compiled in with the llama build tag.
+func newLlamaBackend(cfg *config.Config) (LLMBackend, error) {
+	return nil, fmt.Errorf("the llama backend is not available in this build (build with -tags llama)")
+}
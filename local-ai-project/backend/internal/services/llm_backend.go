@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+)
+
+// Supported values for config.LLMBackend
+const (
+	BackendOllama = "ollama"
+	BackendOpenAI = "openai"
+	BackendLlama  = "llama"
+)
+
+// LLMBackend is a model runtime that generates text and embeddings. When
+// onToken is nil the response is returned in one piece, otherwise onToken
+// receives the text as it is generated and may return an error to stop.
+type LLMBackend interface {
+	Generate(ctx context.Context, model, prompt string, opts types.GenerationOptions,
+		onToken func(string) error) (string, GenerationStats, error)
+	Chat(ctx context.Context, model string, messages []types.ChatMessage, opts types.GenerationOptions,
+		onToken func(string) error) (string, GenerationStats, error)
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// ModelPuller is implemented by backends that can download models themselves
+type ModelPuller interface {
+	Pull(ctx context.Context, model string, onProgress func(PullProgress)) error
+}
+
+// ModelCreator is implemented by backends that can create a model from a
+// local GGUF file
+type ModelCreator interface {
+	CreateModel(ctx context.Context, name string, modelfile Modelfile, onProgress func(PullProgress)) error
+}
+
+// GenerationStats are the token counts a backend reports for a response
+type GenerationStats struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// NewLLMBackend creates the backend selected in the configuration
+func NewLLMBackend(cfg *config.Config) (LLMBackend, error) {
+	switch strings.ToLower(cfg.LLMBackend) {
+	case "", BackendOllama:
+		return newOllamaBackend(cfg), nil
+	case BackendOpenAI:
+		return newOpenAIBackend(cfg), nil
+	case BackendLlama:
+		return newLlamaBackend(cfg)
+	default:
+		return nil, fmt.Errorf("unknown LLM backend %q (use ollama, openai or llama)", cfg.LLMBackend)
+	}
+}
+
+// chatPrompt flattens chat messages into a single prompt for backends that
+// only complete raw text.
+func chatPrompt(messages []types.ChatMessage) string {
+	var prompt strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			prompt.WriteString("### System:\n")
+		case "assistant":
+			prompt.WriteString("### Assistant:\n")
+		default:
+			prompt.WriteString("### User:\n")
+		}
+		prompt.WriteString(strings.TrimSpace(msg.Content))
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("### Assistant:\n")
+	return prompt.String()
+}
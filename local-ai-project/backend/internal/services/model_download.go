@@ -0,0 +1,366 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// Downloads land in name + partialSuffix and are renamed once complete
+const partialSuffix = ".partial"
+
+// Times a dropped download is resumed before giving up
+const downloadAttempts = 3
+
+// A download that receives nothing for this long counts as dropped
+const downloadStallTimeout = time.Minute
+
+// ErrChecksumMismatch is returned when a download doesn't match the expected SHA-256
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Model download states, reported as types.DownloadJob.Status
+const (
+	DownloadRunning   = "downloading"
+	DownloadVerifying = "verifying"
+	DownloadDone      = "available"
+	DownloadFailed    = "failed"
+	DownloadCancelled = "cancelled"
+)
+
+// downloadJob tracks a download running in the background
+type downloadJob struct {
+	job        types.DownloadJob
+	started    time.Time
+	firstBytes int64 // bytes already on disk when the job started, -1 until known
+	cancel     context.CancelFunc
+}
+
+// snapshot adds the progress, rate and ETA as of now
+func (d *downloadJob) snapshot() types.DownloadJob {
+	job := d.job
+	if job.BytesTotal > 0 {
+		job.Progress = float64(job.BytesDone) / float64(job.BytesTotal) * 100
+	}
+	if job.Status == DownloadDone {
+		job.Progress = 100
+	}
+
+	// Resumed bytes didn't take any time, leave them out of the rate
+	if elapsed := time.Since(d.started).Seconds(); job.Status == DownloadRunning && d.firstBytes >= 0 && elapsed > 0 {
+		job.Rate = float64(job.BytesDone-d.firstBytes) / elapsed
+		if job.Rate > 0 && job.BytesTotal > job.BytesDone {
+			job.ETA = float64(job.BytesTotal-job.BytesDone) / job.Rate
+		}
+	}
+	return job
+}
+
+// downloadRegistry keeps the downloads started since the server came up
+type downloadRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*downloadJob
+}
+
+// start downloads url to path in the background unless the same model is
+// already downloading. onDone gets the finished job and, if it succeeded,
+// the file's SHA-256.
+func (r *downloadRegistry) start(name, url, path, expectedSHA256 string,
+	onDone func(job types.DownloadJob, checksum string)) types.DownloadJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.jobs == nil {
+		r.jobs = make(map[string]*downloadJob)
+	}
+	if existing, ok := r.jobs[name]; ok && (existing.job.Status == DownloadRunning || existing.job.Status == DownloadVerifying) {
+		return existing.snapshot()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &downloadJob{
+		job: types.DownloadJob{
+			Name:      name,
+			URL:       url,
+			Status:    DownloadRunning,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		started:    time.Now(),
+		firstBytes: -1,
+		cancel:     cancel,
+	}
+	r.jobs[name] = d
+
+	go func() {
+		defer cancel()
+
+		err := fetchWithRetries(ctx, url, path+partialSuffix, func(done, total int64) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if d.firstBytes < 0 {
+				d.firstBytes = done
+			}
+			d.job.BytesDone = done
+			if total > 0 {
+				d.job.BytesTotal = total
+			}
+		})
+		var checksum string
+		if err == nil {
+			r.mu.Lock()
+			d.job.Status = DownloadVerifying
+			r.mu.Unlock()
+			checksum, err = publishDownload(ctx, path, expectedSHA256)
+		}
+
+		// Once the file is published the download is done, even if a
+		// cancel arrived meanwhile
+		r.mu.Lock()
+		switch {
+		case err == nil:
+			d.job.Status = DownloadDone
+		case ctx.Err() != nil:
+			d.job.Status = DownloadCancelled
+			os.Remove(path + partialSuffix)
+		default:
+			d.job.Status = DownloadFailed
+			d.job.Error = err.Error()
+			log.Printf("Warning: failed to download model %s: %v", name, err)
+		}
+		d.job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		job := d.snapshot()
+		r.mu.Unlock()
+
+		if onDone != nil {
+			onDone(job, checksum)
+		}
+	}()
+
+	return d.snapshot()
+}
+
+func (r *downloadRegistry) cancel(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.jobs[name]
+	if !ok || d.job.Status != DownloadRunning {
+		return fmt.Errorf("model %s is not being downloaded", name)
+	}
+	d.cancel()
+	return nil
+}
+
+func (r *downloadRegistry) get(name string) (types.DownloadJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.jobs[name]
+	if !ok {
+		return types.DownloadJob{}, false
+	}
+	return d.snapshot(), true
+}
+
+func (r *downloadRegistry) list() []types.DownloadJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]types.DownloadJob, 0, len(r.jobs))
+	for _, d := range r.jobs {
+		jobs = append(jobs, d.snapshot())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// fetchWithRetries downloads url into the partial file. The data that is
+// already there is kept and the rest fetched with an HTTP Range request,
+// so a dropped connection resumes on the next attempt or the next job.
+func fetchWithRetries(ctx context.Context, url, partial string, onProgress func(done, total int64)) error {
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if err = fetchRemaining(ctx, url, partial, onProgress); err == nil || ctx.Err() != nil {
+			return err
+		}
+		if attempt < downloadAttempts {
+			log.Printf("Warning: download of %s interrupted, resuming: %v", url, err)
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+// publishDownload checks the partial file against expectedSHA256, if given,
+// and that it is a GGUF model, and renames it to path. It returns the
+// file's SHA-256.
+func publishDownload(ctx context.Context, path, expectedSHA256 string) (string, error) {
+	partial := path + partialSuffix
+
+	sum, err := fileSHA256(partial)
+	if err != nil {
+		return "", err
+	}
+	if expectedSHA256 != "" && !strings.EqualFold(sum, expectedSHA256) {
+		// Resuming can't repair a corrupt file
+		os.Remove(partial)
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, strings.ToLower(expectedSHA256), sum)
+	}
+
+	// An HTML error page or a truncated file would only fail once loaded
+	if _, err := readGGUFFile(partial); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	// Cancelling is possible up to here, not after the file is in place
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		return "", fmt.Errorf("failed to move model file into place: %w", err)
+	}
+	return sum, nil
+}
+
+// fetchRemaining appends the bytes missing from the partial file
+func fetchRemaining(ctx context.Context, url, partial string, onProgress func(done, total int64)) error {
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create model file: %w", err)
+	}
+	defer out.Close()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	// Cancel the request when the connection stalls, which a dead peer
+	// often does instead of closing it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stall := time.AfterFunc(downloadStallTimeout, cancel)
+	defer stall.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to download model: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download model: %w", err)
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return fmt.Errorf("failed to download model: server resumed at the wrong offset")
+		}
+		total = size
+	case http.StatusOK:
+		// The server ignored the range, start over
+		if err := out.Truncate(0); err != nil {
+			return fmt.Errorf("failed to reset model file: %w", err)
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to reset model file: %w", err)
+		}
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to fetch, the previous attempt got everything
+		if offset > 0 {
+			onProgress(offset, offset)
+			return nil
+		}
+		return fmt.Errorf("failed to download model: HTTP %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("failed to download model: HTTP %d", resp.StatusCode)
+	}
+
+	onProgress(offset, total)
+	body := &stallReader{r: resp.Body, timer: stall, onRead: func(n int) {
+		offset += int64(n)
+		onProgress(offset, total)
+	}}
+	if _, err := io.Copy(out, body); err != nil {
+		return fmt.Errorf("failed to save model file: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to save model file: %w", err)
+	}
+	return out.Close()
+}
+
+// stallReader restarts the stall timer and reports progress whenever data arrives
+type stallReader struct {
+	r      io.Reader
+	timer  *time.Timer
+	onRead func(n int)
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(downloadStallTimeout)
+		r.onRead(n)
+	}
+	return n, err
+}
+
+// parseContentRange parses the first byte position and the full size of
+// "bytes 100-199/200". The size is 0 if the server sent "*".
+func parseContentRange(contentRange string) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	byteRange, size, _ := strings.Cut(spec, "/")
+	first, _, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total, _ := strconv.ParseInt(size, 10, 64)
+	return start, total, true
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open model file: %w", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash model file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
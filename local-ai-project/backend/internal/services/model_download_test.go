@@ -0,0 +1,28 @@
+package services
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{"bytes 100-199/*", 100, 0, true}, // size unknown
+		{"bytes 100-199", 100, 0, true},
+		{"bytes */200", 0, 0, false},
+		{"bytes -199/200", 0, 0, false},
+		{"items 100-199/200", 0, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, size, ok := parseContentRange(tt.header)
+		if start != tt.start || size != tt.size || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v",
+				tt.header, start, size, ok, tt.start, tt.size, tt.ok)
+		}
+	}
+}
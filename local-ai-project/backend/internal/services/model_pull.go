@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// Model pull states, reported as types.Model.Status
+const (
+	PullPulling   = "pulling"
+	PullReady     = "available"
+	PullFailed    = "failed"
+	PullCancelled = "cancelled"
+)
+
+// finishedPullTTL is how long a finished pull keeps its final status for
+// clients polling it
+const finishedPullTTL = 10 * time.Minute
+
+// PullProgress is one progress update of a model pull. Digest and the byte
+// counts are set while a layer downloads.
+type PullProgress struct {
+	Status    string
+	Digest    string
+	Total     int64
+	Completed int64
+}
+
+// modelPull tracks a pull running in the background
+type modelPull struct {
+	name    string
+	status  string
+	message string // latest status line from the backend
+	err     string
+	layers  map[string]PullProgress
+	cancel  context.CancelFunc
+
+	finishedAt time.Time // zero while running
+}
+
+// progress is the share of all known layer bytes downloaded so far
+func (p *modelPull) progress() float64 {
+	if p.status == PullReady {
+		return 100
+	}
+
+	var total, completed int64
+	for _, layer := range p.layers {
+		total += layer.Total
+		completed += layer.Completed
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(completed) / float64(total) * 100
+}
+
+func (p *modelPull) model() types.Model {
+	return types.Model{
+		ID:               p.name,
+		Name:             p.name,
+		Status:           p.status,
+		DownloadProgress: p.progress(),
+		Description:      p.message,
+		Error:            p.err,
+		ModelType:        "chat",
+	}
+}
+
+// pullRegistry keeps the pulls in progress and, for a while, the outcome
+// of finished ones
+type pullRegistry struct {
+	mu    sync.Mutex
+	pulls map[string]*modelPull
+}
+
+// start pulls a model in the background with run unless it is already being
+// pulled. Imports of local files run through here too.
+func (r *pullRegistry) start(name string, run func(ctx context.Context, onProgress func(PullProgress)) error,
+	onDone func()) types.Model {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pulls == nil {
+		r.pulls = make(map[string]*modelPull)
+	}
+	r.prune()
+	if existing, ok := r.pulls[name]; ok && existing.status == PullPulling {
+		return existing.model()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pull := &modelPull{
+		name:   name,
+		status: PullPulling,
+		layers: make(map[string]PullProgress),
+		cancel: cancel,
+	}
+	r.pulls[name] = pull
+
+	go func() {
+		defer cancel()
+
+		err := run(ctx, func(update PullProgress) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			pull.message = update.Status
+			if update.Digest != "" && update.Total > 0 {
+				pull.layers[update.Digest] = update
+			}
+		})
+
+		r.mu.Lock()
+		switch {
+		case ctx.Err() != nil:
+			pull.status = PullCancelled
+		case err != nil:
+			pull.status = PullFailed
+			pull.err = err.Error()
+			log.Printf("Warning: failed to pull model %s: %v", name, err)
+		default:
+			pull.status = PullReady
+		}
+		pull.message = ""
+		pull.finishedAt = time.Now()
+		status := pull.status
+		r.mu.Unlock()
+
+		if status == PullReady && onDone != nil {
+			onDone()
+		}
+	}()
+
+	return pull.model()
+}
+
+func (r *pullRegistry) cancel(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pull, ok := r.pulls[name]
+	if !ok || pull.status != PullPulling {
+		return fmt.Errorf("model %s is not being pulled", name)
+	}
+	pull.cancel()
+	return nil
+}
+
+// get returns a running pull or a recently finished one
+func (r *pullRegistry) get(name string) (types.Model, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune()
+	pull, ok := r.pulls[name]
+	if !ok {
+		return types.Model{}, fmt.Errorf("model %s has no recent pull", name)
+	}
+	return pull.model(), nil
+}
+
+// list returns the running pulls. Finished ones show up as models or, if
+// they failed, only through get.
+func (r *pullRegistry) list() []types.Model {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	models := make([]types.Model, 0, len(r.pulls))
+	for _, pull := range r.pulls {
+		if pull.status == PullPulling {
+			models = append(models, pull.model())
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models
+}
+
+// prune drops finished pulls older than finishedPullTTL. The caller holds r.mu.
+func (r *pullRegistry) prune() {
+	for name, pull := range r.pulls {
+		if !pull.finishedAt.IsZero() && time.Since(pull.finishedAt) > finishedPullTTL {
+			delete(r.pulls, name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long the list of installed models is reused
+const installedModelsTTL = 30 * time.Second
+
+// UnknownModelError is returned when a requested model isn't installed in the backend
+type UnknownModelError struct {
+	Name      string
+	Available []string
+}
+
+func (e *UnknownModelError) Error() string {
+	return fmt.Sprintf("model %s is not available", e.Name)
+}
+
+// modelResolver picks the model for a request and checks it against the
+// models the backend has installed.
+type modelResolver struct {
+	backend LLMBackend
+
+	mu           sync.Mutex
+	defaultModel string
+	installed    []string
+	fetchedAt    time.Time
+}
+
+func newModelResolver(backend LLMBackend, defaultModel string) *modelResolver {
+	return &modelResolver{
+		backend:      backend,
+		defaultModel: defaultModel,
+	}
+}
+
+func (r *modelResolver) setDefault(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaultModel = name
+}
+
+func (r *modelResolver) getDefault() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.defaultModel
+}
+
+// resolve returns the installed model name for a request, falling back to
+// the default model when none was requested.
+func (r *modelResolver) resolve(requested string) (string, error) {
+	name := strings.TrimSpace(requested)
+	if name == "" {
+		name = r.getDefault()
+	}
+	if name == "" {
+		return "", fmt.Errorf("no model requested and no default model configured")
+	}
+
+	installed, err := r.installedModels(false)
+	if err != nil {
+		return "", err
+	}
+	if match := matchModel(name, installed); match != "" {
+		return match, nil
+	}
+
+	// The model may have been pulled since the list was fetched
+	installed, err = r.installedModels(true)
+	if err != nil {
+		return "", err
+	}
+	if match := matchModel(name, installed); match != "" {
+		return match, nil
+	}
+
+	return "", &UnknownModelError{Name: name, Available: installed}
+}
+
+func (r *modelResolver) installedModels(refresh bool) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !refresh && r.installed != nil && time.Since(r.fetchedAt) < installedModelsTTL {
+		return r.installed, nil
+	}
+
+	installed, err := r.backend.ListModels(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	r.installed = installed
+	r.fetchedAt = time.Now()
+	return installed, nil
+}
+
+// matchModel finds name among the installed models. A name without a tag
+// also matches the "latest" tag, like it does in Ollama.
+func matchModel(name string, installed []string) string {
+	for _, model := range installed {
+		if strings.EqualFold(model, name) {
+			return model
+		}
+	}
+	if !strings.Contains(name, ":") {
+		return matchModel(name+":latest", installed)
+	}
+	return ""
+}
@@ -3,110 +3,409 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"local-ai-project/backend/internal/config"
 	"local-ai-project/backend/pkg/types"
 )
 
+// Model file states stored in the models table, next to the download states
+const (
+	ModelInvalid = "invalid" // not a GGUF file
+	ModelMissing = "missing" // removed from the models directory
+)
+
+var (
+	ErrModelNotFound    = errors.New("model not found")
+	ErrModelUnavailable = errors.New("model file can't be used")
+	ErrInvalidModelName = errors.New("invalid model name")
+	ErrModelDownloading = errors.New("model is still downloading")
+	ErrInvalidSystem    = errors.New("system message can't contain \"\"\"")
+)
+
+// ollamaModelName matches the names Ollama accepts, an optional namespace,
+// the model and an optional tag
+var ollamaModelName = regexp.MustCompile(`^([a-z0-9][a-z0-9._-]*/)?[a-z0-9][a-z0-9._-]*(:[a-zA-Z0-9._-]+)?$`)
+
 type ModelService struct {
 	config *config.Config
 	db     *sql.DB
+
+	downloads downloadRegistry
 }
 
 func NewModelService(cfg *config.Config, db *sql.DB) *ModelService {
 	return &ModelService{config: cfg, db: db}
 }
 
+// ListModels returns the models in the models table, with live progress
+// for downloads
 func (s *ModelService) ListModels() ([]types.Model, error) {
-	// List downloaded models from filesystem
-	var models []types.Model
+	rows, err := s.db.Query(`SELECT name, size, status, source_url, sha256, format, model_type, architecture,
+								parameter_count, quantization, context_length, embedding_length, chat_template,
+								error_message, COALESCE(last_used_at, ''), imported_as
+							 FROM models ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	models := []types.Model{}
+	for rows.Next() {
+		var model types.Model
+		var size sql.NullInt64
+		if err := rows.Scan(&model.Name, &size, &model.Status, &model.SourceURL, &model.SHA256, &model.Format,
+			&model.ModelType, &model.Architecture, &model.ParameterCount, &model.Quantization,
+			&model.ContextLength, &model.EmbeddingLength, &model.ChatTemplate, &model.Error,
+			&model.LastUsedAt, &model.ImportedAs); err != nil {
+			return nil, err
+		}
+		model.ID = model.Name
+		model.SizeBytes = size.Int64
+
+		if job, ok := s.downloads.get(model.Name); ok && (job.Status == DownloadRunning || job.Status == DownloadVerifying) {
+			model.Status = job.Status
+			model.DownloadProgress = job.Progress
+			model.SizeBytes = job.BytesTotal
+		}
+		model.Size = formatSize(model.SizeBytes)
+		models = append(models, model)
+	}
+
+	return models, rows.Err()
+}
+
+// formatSize shows a byte count in MB or GB with one decimal
+func formatSize(bytes int64) string {
+	if bytes >= 1<<30 {
+		return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+	}
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
+
+// recordFile stores a file in the models directory with the metadata from
+// its GGUF header. An empty sourceURL or checksum keeps the stored one.
+func (s *ModelService) recordFile(name, sourceURL, checksum string) error {
+	path := filepath.Join(s.config.ModelsPath, name)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	status, format, modelType, errorMessage := "available", "gguf", "chat", ""
+	info, err := readGGUFFile(path)
+	if err != nil {
+		status, format, modelType, errorMessage = ModelInvalid, "", "unknown", err.Error()
+		info = &ggufInfo{}
+	} else if info.Embedding {
+		modelType = "embedding"
+	}
 
-	files, err := os.ReadDir(s.config.ModelsPath)
+	_, err = s.db.Exec(`INSERT INTO models (name, path, size, status, source_url, sha256, format, model_type,
+							architecture, parameter_count, quantization, context_length, embedding_length,
+							chat_template, error_message)
+						VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+						ON CONFLICT (name) DO UPDATE SET
+							path = excluded.path, size = excluded.size, status = excluded.status,
+							source_url = COALESCE(NULLIF(excluded.source_url, ''), source_url),
+							sha256 = COALESCE(NULLIF(excluded.sha256, ''), sha256),
+							format = excluded.format, model_type = excluded.model_type,
+							architecture = excluded.architecture, parameter_count = excluded.parameter_count,
+							quantization = excluded.quantization, context_length = excluded.context_length,
+							embedding_length = excluded.embedding_length, chat_template = excluded.chat_template,
+							error_message = excluded.error_message`,
+		name, path, stat.Size(), status, sourceURL, checksum, format, modelType,
+		info.Architecture, info.ParameterCount, info.Quantization, info.ContextLength, info.EmbeddingLength,
+		info.ChatTemplate, errorMessage)
 	if err != nil {
-		return models, nil // Return empty list if directory doesn't exist
+		return fmt.Errorf("failed to store model %s: %w", name, err)
 	}
+	return nil
+}
 
+// Reconcile brings the models table in line with the models directory:
+// files added while the server was down are recorded, removed ones marked
+// missing, and downloads cut off by a restart marked failed.
+func (s *ModelService) Reconcile() error {
+	files, _ := os.ReadDir(s.config.ModelsPath) // empty if the directory doesn't exist
+	onDisk := map[string]int64{}
 	for _, file := range files {
-		if !file.IsDir() {
-			info, _ := file.Info()
-			models = append(models, types.Model{
-				ID:        file.Name(),
-				Name:      file.Name(),
-				Size:      fmt.Sprintf("%d MB", info.Size()/(1024*1024)),
-				Status:    "available",
-				ModelType: "chat",
-			})
+		// Unfinished downloads aren't usable yet
+		if file.IsDir() || strings.HasSuffix(file.Name(), partialSuffix) {
+			continue
+		}
+		if stat, err := file.Info(); err == nil {
+			onDisk[file.Name()] = stat.Size()
+		}
+	}
+
+	rows, err := s.db.Query(`SELECT name, size, status FROM models`)
+	if err != nil {
+		return err
+	}
+	stored := map[string]struct {
+		size   int64
+		status string
+	}{}
+	for rows.Next() {
+		var name, status string
+		var size sql.NullInt64
+		if err := rows.Scan(&name, &size, &status); err != nil {
+			rows.Close()
+			return err
 		}
+		stored[name] = struct {
+			size   int64
+			status string
+		}{size.Int64, status}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return models, nil
+	var added, changed, missing int
+	for name, size := range onDisk {
+		row, ok := stored[name]
+		if ok && row.size == size && (row.status == "available" || row.status == ModelInvalid) {
+			continue
+		}
+		if ok && row.size != size {
+			// A replaced file no longer matches the stored checksum
+			if _, err := s.db.Exec(`UPDATE models SET sha256 = '' WHERE name = ?`, name); err != nil {
+				return err
+			}
+			changed++
+		} else if !ok {
+			added++
+		}
+		if err := s.recordFile(name, "", ""); err != nil {
+			return err
+		}
+	}
+
+	for name, row := range stored {
+		if _, ok := onDisk[name]; ok {
+			continue
+		}
+		switch row.status {
+		case DownloadRunning, DownloadVerifying:
+			_, err = s.db.Exec(`UPDATE models SET status = ?, error_message = ? WHERE name = ?`,
+				DownloadFailed, "download interrupted by a server restart, download again to resume", name)
+		case ModelMissing, DownloadFailed:
+			continue
+		default:
+			_, err = s.db.Exec(`UPDATE models SET status = ? WHERE name = ?`, ModelMissing, name)
+			missing++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if added+changed+missing > 0 {
+		log.Printf("Models directory changed: %d added, %d changed, %d missing", added, changed, missing)
+	}
+	return nil
 }
 
-func (s *ModelService) DownloadModel(name, url string) error {
+// DownloadModel starts downloading a model file into the models directory
+// in the background. An interrupted download is resumed by the next call for
+// the same name. The file only shows up as available once it is complete
+// and, if expectedSHA256 is set, verified.
+func (s *ModelService) DownloadModel(name, url, expectedSHA256 string) (types.DownloadJob, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasSuffix(name, partialSuffix) {
+		return types.DownloadJob{}, fmt.Errorf("invalid model name %s", name)
+	}
+
 	// Create the models directory if it doesn't exist
 	if err := os.MkdirAll(s.config.ModelsPath, 0755); err != nil {
-		return fmt.Errorf("failed to create models directory: %w", err)
+		return types.DownloadJob{}, fmt.Errorf("failed to create models directory: %w", err)
 	}
 
-	// Download the model file
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to download model: %w", err)
+	if job, ok := s.downloads.get(name); ok && (job.Status == DownloadRunning || job.Status == DownloadVerifying) {
+		return job, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download model: HTTP %d", resp.StatusCode)
+	path := filepath.Join(s.config.ModelsPath, name)
+	if _, err := s.db.Exec(`INSERT INTO models (name, path, status, source_url, error_message) VALUES (?, ?, ?, ?, '')
+							ON CONFLICT (name) DO UPDATE SET status = excluded.status,
+								source_url = excluded.source_url, error_message = ''`,
+		name, path, DownloadRunning, url); err != nil {
+		return types.DownloadJob{}, fmt.Errorf("failed to store model %s: %w", name, err)
 	}
 
-	// Create the destination file
-	filePath := filepath.Join(s.config.ModelsPath, name)
-	out, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create model file: %w", err)
+	return s.downloads.start(name, url, path, expectedSHA256, s.finishDownload), nil
+}
+
+// finishDownload records the outcome of a download in the models table. A
+// failed or cancelled download of a model that was already on disk leaves
+// the existing file in place.
+func (s *ModelService) finishDownload(job types.DownloadJob, checksum string) {
+	var err error
+	_, statErr := os.Stat(filepath.Join(s.config.ModelsPath, job.Name))
+
+	switch {
+	case job.Status == DownloadDone:
+		err = s.recordFile(job.Name, job.URL, checksum)
+	case statErr == nil:
+		err = s.recordFile(job.Name, "", "")
+	case job.Status == DownloadCancelled:
+		_, err = s.db.Exec(`DELETE FROM models WHERE name = ?`, job.Name)
+	default:
+		_, err = s.db.Exec(`UPDATE models SET status = ?, error_message = ? WHERE name = ?`,
+			DownloadFailed, job.Error, job.Name)
 	}
-	defer out.Close()
 
-	// Copy the response body to the file
-	_, err = io.Copy(out, resp.Body)
 	if err != nil {
-		// Clean up partial file on error
-		os.Remove(filePath)
-		return fmt.Errorf("failed to save model file: %w", err)
+		log.Printf("Warning: failed to record download of model %s: %v", job.Name, err)
 	}
+}
 
-	return nil
+// Download returns the state of a model download
+func (s *ModelService) Download(name string) (types.DownloadJob, error) {
+	job, ok := s.downloads.get(name)
+	if !ok {
+		return job, fmt.Errorf("no download of model %s", name)
+	}
+	return job, nil
 }
 
-func (s *ModelService) LoadModel(name string) error {
-	filePath := filepath.Join(s.config.ModelsPath, name)
+// Downloads returns the downloads started since the server came up
+func (s *ModelService) Downloads() []types.DownloadJob {
+	return s.downloads.list()
+}
 
-	// Check if model file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("model %s not found", name)
+// CancelDownload stops a running download and removes its partial file
+func (s *ModelService) CancelDownload(name string) error {
+	return s.downloads.cancel(name)
+}
+
+// MarkUsed records that a model was loaded. Names without the file
+// extension match too, as backends list them either way, and so do the
+// backend models imported from a file, with or without the latest tag.
+func (s *ModelService) MarkUsed(name string) error {
+	_, err := s.db.Exec(`UPDATE models SET last_used_at = CURRENT_TIMESTAMP
+						 WHERE name = ? OR name = ? OR imported_as = ? OR imported_as || ':latest' = ?`,
+		name, name+".gguf", name, name)
+	return err
+}
+
+// ImportModelfile builds the Modelfile for importing a model file into the
+// backend and returns it with the backend model name. The prompt template
+// and stop sequences come from the chat template in the GGUF header unless
+// the request sets them.
+func (s *ModelService) ImportModelfile(req types.ImportModelRequest) (string, Modelfile, error) {
+	var path, status, checksum, modelType, chatTemplate string
+	var contextLength int
+	err := s.db.QueryRow(`SELECT path, status, sha256, model_type, context_length, chat_template
+						  FROM models WHERE name = ?`, req.File).
+		Scan(&path, &status, &checksum, &modelType, &contextLength, &chatTemplate)
+	if err == sql.ErrNoRows {
+		return "", Modelfile{}, fmt.Errorf("%w: %s", ErrModelNotFound, req.File)
+	}
+	if err != nil {
+		return "", Modelfile{}, fmt.Errorf("failed to get model %s: %w", req.File, err)
+	}
+	if status != "available" {
+		return "", Modelfile{}, fmt.Errorf("%w: %s is %s", ErrModelUnavailable, req.File, status)
 	}
 
-	// TODO: Implement actual model loading logic
-	// For now, just return success
-	return nil
+	name := req.Name
+	if name == "" {
+		name = importName(req.File)
+	}
+	if !ollamaModelName.MatchString(name) {
+		return "", Modelfile{}, fmt.Errorf("%w: %s", ErrInvalidModelName, name)
+	}
+
+	// Unlike the template, the system message has no way to escape the
+	// quotes that end it in the Modelfile
+	if strings.Contains(req.System, `"""`) {
+		return "", Modelfile{}, ErrInvalidSystem
+	}
+
+	modelfile := Modelfile{From: path, Digest: checksum, Template: req.Template, System: req.System}
+
+	// Generation settings mean nothing to an embedding model
+	if modelType != "embedding" {
+		modelfile.Parameters = generationOptions(s.config.Generation, req.Parameters)
+		if format, ok := detectChatFormat(chatTemplate); ok {
+			if modelfile.Template == "" {
+				modelfile.Template = format.template
+			}
+			if len(modelfile.Parameters.Stop) == 0 {
+				modelfile.Parameters.Stop = format.stop
+			}
+		}
+		if modelfile.System == "" {
+			modelfile.System = defaultModelfileSystem
+		}
+	} else if req.Parameters != nil && req.Parameters.NumCtx != nil {
+		modelfile.Parameters.NumCtx = req.Parameters.NumCtx
+	}
+
+	// Ollama's default context is far shorter than most models are trained
+	// for, which would cut retrieved sources out of the prompt
+	if modelfile.Parameters.NumCtx == nil && contextLength > 0 {
+		modelfile.Parameters.NumCtx = ptr(clampInt(contextLength, 512, s.config.Generation.MaxNumCtx))
+	}
+
+	return name, modelfile, nil
+}
+
+// MarkImported records the backend model created from a model file
+func (s *ModelService) MarkImported(file, name string) error {
+	_, err := s.db.Exec(`UPDATE models SET imported_as = ? WHERE name = ?`, name, file)
+	return err
+}
+
+// importName derives a backend model name from a file name, as in
+// "Llama-3.2-3B-Instruct-Q4_K_M.gguf" -> "llama-3.2-3b-instruct-q4_k_m"
+func importName(file string) string {
+	name := strings.ToLower(strings.TrimSuffix(file, filepath.Ext(file)))
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	return strings.Trim(name, "-._")
 }
 
 func (s *ModelService) DeleteModel(name string) error {
+	// The download would put the file and its record back when it finishes
+	if job, ok := s.downloads.get(name); ok && (job.Status == DownloadRunning || job.Status == DownloadVerifying) {
+		return fmt.Errorf("%w: cancel the download of %s first", ErrModelDownloading, name)
+	}
+
 	filePath := filepath.Join(s.config.ModelsPath, name)
 
-	// Check if file exists
+	result, err := s.db.Exec(`DELETE FROM models WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete model %s: %w", name, err)
+	}
+	recorded, _ := result.RowsAffected()
+
+	// Check if file exists, a missing model only has its record left
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("model %s not found", name)
+		if recorded > 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrModelNotFound, name)
 	}
 
 	// Delete the model file
 	if err := os.Remove(filePath); err != nil {
 		return fmt.Errorf("failed to delete model %s: %w", name, err)
 	}
+	// and any download of a newer copy
+	os.Remove(filePath + partialSuffix)
 	return nil
 }
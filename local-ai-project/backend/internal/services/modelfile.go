@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// defaultModelfileSystem is the SYSTEM of imported chat models when the
+// import doesn't set one
+const defaultModelfileSystem = "You are a helpful assistant. Answer accurately and concisely."
+
+// Modelfile describes a model to create in the backend from a local GGUF
+// file, in the terms of an Ollama Modelfile
+type Modelfile struct {
+	From       string // path of the GGUF file
+	Digest     string // SHA-256 of the file, computed when empty
+	Template   string // prompt template, the backend detects one when empty
+	System     string
+	Parameters types.GenerationOptions
+}
+
+// String renders the Modelfile with the file path in FROM
+func (m Modelfile) String() string {
+	return m.render(m.From)
+}
+
+// render writes the Modelfile text with from as the FROM line, which is a
+// path or an uploaded blob
+func (m Modelfile) render(from string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", from)
+	if m.Template != "" {
+		fmt.Fprintf(&b, "TEMPLATE \"\"\"%s\"\"\"\n", escapeTemplateQuotes(m.Template))
+	}
+	for _, line := range m.parameterLines() {
+		fmt.Fprintf(&b, "PARAMETER %s\n", line)
+	}
+	if m.System != "" {
+		fmt.Fprintf(&b, "SYSTEM \"\"\"%s\"\"\"\n", m.System)
+	}
+	return b.String()
+}
+
+// parameterLines lists the set parameters as "name value" in name order,
+// one line per stop sequence
+func (m Modelfile) parameterLines() []string {
+	p := m.Parameters
+	var lines []string
+	if p.NumCtx != nil {
+		lines = append(lines, fmt.Sprintf("num_ctx %d", *p.NumCtx))
+	}
+	if p.NumPredict != nil {
+		lines = append(lines, fmt.Sprintf("num_predict %d", *p.NumPredict))
+	}
+	if p.RepeatPenalty != nil {
+		lines = append(lines, "repeat_penalty "+formatParameterFloat(*p.RepeatPenalty))
+	}
+	if p.Seed != nil {
+		lines = append(lines, fmt.Sprintf("seed %d", *p.Seed))
+	}
+	for _, stop := range p.Stop {
+		lines = append(lines, fmt.Sprintf("stop %q", stop))
+	}
+	if p.Temperature != nil {
+		lines = append(lines, "temperature "+formatParameterFloat(*p.Temperature))
+	}
+	if p.TopK != nil {
+		lines = append(lines, fmt.Sprintf("top_k %d", *p.TopK))
+	}
+	if p.TopP != nil {
+		lines = append(lines, "top_p "+formatParameterFloat(*p.TopP))
+	}
+	return lines
+}
+
+// formatParameterFloat writes v in plain decimal, never in the exponent
+// form %v picks for large or tiny values
+func formatParameterFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escapeTemplateQuotes breaks up triple quotes in a prompt template, which
+// would end the TEMPLATE value early. The Modelfile has no escapes, so the
+// third quote is written as a template action printing it.
+func escapeTemplateQuotes(template string) string {
+	return strings.ReplaceAll(template, `"""`, `""{{ "\"" }}`)
+}
+
+// chatFormat is a prompt format recognized by a marker in the chat
+// template of a GGUF file. The templates use Ollama's Go template syntax.
+type chatFormat struct {
+	markers  []string
+	template string
+	stop     []string
+}
+
+// chatFormats are checked in order, the first whose markers all appear wins
+var chatFormats = []chatFormat{
+	// Llama 3
+	{
+		markers: []string{"<|start_header_id|>"},
+		template: `{{ if .System }}<|start_header_id|>system<|end_header_id|>
+
+{{ .System }}<|eot_id|>{{ end }}{{ if .Prompt }}<|start_header_id|>user<|end_header_id|>
+
+{{ .Prompt }}<|eot_id|>{{ end }}<|start_header_id|>assistant<|end_header_id|>
+
+{{ .Response }}<|eot_id|>`,
+		stop: []string{"<|start_header_id|>", "<|end_header_id|>", "<|eot_id|>"},
+	},
+	// ChatML, used by Qwen and many fine-tunes
+	{
+		markers: []string{"<|im_start|>"},
+		template: `{{ if .System }}<|im_start|>system
+{{ .System }}<|im_end|>
+{{ end }}{{ if .Prompt }}<|im_start|>user
+{{ .Prompt }}<|im_end|>
+{{ end }}<|im_start|>assistant
+{{ .Response }}<|im_end|>`,
+		stop: []string{"<|im_start|>", "<|im_end|>"},
+	},
+	// Gemma
+	{
+		markers: []string{"<start_of_turn>"},
+		template: `<start_of_turn>user
+{{ if .System }}{{ .System }}
+
+{{ end }}{{ .Prompt }}<end_of_turn>
+<start_of_turn>model
+{{ .Response }}<end_of_turn>`,
+		stop: []string{"<start_of_turn>", "<end_of_turn>"},
+	},
+	// Phi-3
+	{
+		markers: []string{"<|user|>", "<|end|>"},
+		template: `{{ if .System }}<|system|>
+{{ .System }}<|end|>
+{{ end }}{{ if .Prompt }}<|user|>
+{{ .Prompt }}<|end|>
+{{ end }}<|assistant|>
+{{ .Response }}<|end|>`,
+		stop: []string{"<|system|>", "<|user|>", "<|assistant|>", "<|end|>"},
+	},
+	// Mistral
+	{
+		markers:  []string{"[INST]"},
+		template: `[INST] {{ if .System }}{{ .System }} {{ end }}{{ .Prompt }} [/INST]{{ .Response }}`,
+		stop:     []string{"[INST]", "[/INST]"},
+	},
+}
+
+// detectChatFormat finds the prompt format of a GGUF chat template
+func detectChatFormat(chatTemplate string) (chatFormat, bool) {
+	for _, format := range chatFormats {
+		matches := true
+		for _, marker := range format.markers {
+			matches = matches && strings.Contains(chatTemplate, marker)
+		}
+		if matches {
+			return format, true
+		}
+	}
+	return chatFormat{}, false
+}
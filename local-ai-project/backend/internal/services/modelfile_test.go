@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+func TestModelfileRender(t *testing.T) {
+	m := Modelfile{
+		From:     "/models/a.gguf",
+		Template: `{{ .Prompt }} """quoted"""`,
+		System:   "Be brief.",
+		Parameters: types.GenerationOptions{
+			Temperature: ptr(0.7),
+			TopP:        ptr(0.00001),
+			NumCtx:      ptr(1000000),
+			Stop:        []string{"<|im_end|>", `"`},
+			Seed:        ptr(42),
+		},
+	}
+
+	want := `FROM /models/a.gguf
+TEMPLATE """{{ .Prompt }} ""{{ "\"" }}quoted""{{ "\"" }}"""
+PARAMETER num_ctx 1000000
+PARAMETER seed 42
+PARAMETER stop "<|im_end|>"
+PARAMETER stop "\""
+PARAMETER temperature 0.7
+PARAMETER top_p 0.00001
+SYSTEM """Be brief."""
+`
+	if got := m.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
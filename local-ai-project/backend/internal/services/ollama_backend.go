@@ -0,0 +1,497 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+)
+
+// ollamaBackend talks to an Ollama server over its HTTP API
+type ollamaBackend struct {
+	baseURL string
+	client  *http.Client
+
+	mu      sync.Mutex
+	windows map[string]int // context window per model
+}
+
+func newOllamaBackend(cfg *config.Config) *ollamaBackend {
+	return &ollamaBackend{
+		baseURL: cfg.OllamaURL,
+		client:  &http.Client{},
+		windows: make(map[string]int),
+	}
+}
+
+// ollamaResponse is one line of a generate or chat response
+type ollamaResponse struct {
+	Response string `json:"response"`
+	Message  struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	Error           string `json:"error"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+func (r ollamaResponse) text() string {
+	return r.Response + r.Message.Content
+}
+
+func (b *ollamaBackend) Generate(ctx context.Context, model, prompt string, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	return b.complete(ctx, "/api/generate", map[string]interface{}{
+		"model":   model,
+		"prompt":  prompt,
+		"stream":  onToken != nil,
+		"options": opts,
+	}, onToken)
+}
+
+func (b *ollamaBackend) Chat(ctx context.Context, model string, messages []types.ChatMessage, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	chatMessages := make([]map[string]string, 0, len(messages))
+	for _, msg := range messages {
+		chatMessages = append(chatMessages, map[string]string{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+
+	return b.complete(ctx, "/api/chat", map[string]interface{}{
+		"model":    model,
+		"messages": chatMessages,
+		"stream":   onToken != nil,
+		"options":  opts,
+	}, onToken)
+}
+
+// complete posts a generate or chat request and reads the single response
+// or, when streaming, the NDJSON lines.
+func (b *ollamaBackend) complete(ctx context.Context, path string, reqBody map[string]interface{},
+	onToken func(string) error) (string, GenerationStats, error) {
+	var stats GenerationStats
+
+	resp, err := b.post(ctx, path, reqBody)
+	if err != nil {
+		return "", stats, fmt.Errorf("failed to generate response: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", stats, fmt.Errorf("AI service error: HTTP %d", resp.StatusCode)
+	}
+
+	if onToken == nil {
+		var response ollamaResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return "", stats, fmt.Errorf("failed to decode response: %w", err)
+		}
+		stats.PromptTokens = response.PromptEvalCount
+		stats.CompletionTokens = response.EvalCount
+		return response.text(), stats, nil
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var text bytes.Buffer
+	for scanner.Scan() {
+		var line ollamaResponse
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return "", stats, fmt.Errorf("failed to decode stream: %w", err)
+		}
+		if line.Error != "" {
+			return "", stats, fmt.Errorf("AI service error: %s", line.Error)
+		}
+
+		if token := line.text(); token != "" {
+			text.WriteString(token)
+			if err := onToken(token); err != nil {
+				return "", stats, err
+			}
+		}
+
+		if line.Done {
+			stats.PromptTokens = line.PromptEvalCount
+			stats.CompletionTokens = line.EvalCount
+			return text.String(), stats, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", stats, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return "", stats, fmt.Errorf("AI service closed the stream early")
+}
+
+func (b *ollamaBackend) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	resp, err := b.post(ctx, "/api/embed", map[string]interface{}{
+		"model": model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Ollama versions before 0.3 only have the single-input endpoint
+	if resp.StatusCode == http.StatusNotFound {
+		return b.embedLegacy(ctx, model, texts)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding service error: HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	return response.Embeddings, nil
+}
+
+func (b *ollamaBackend) embedLegacy(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+
+	for _, text := range texts {
+		resp, err := b.post(ctx, "/api/embeddings", map[string]interface{}{
+			"model":  model,
+			"prompt": text,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+		}
+
+		var response struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("embedding service error: HTTP %d", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode embedding: %w", err)
+		}
+
+		vectors = append(vectors, response.Embedding)
+	}
+
+	return vectors, nil
+}
+
+func (b *ollamaBackend) ListModels(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list Ollama models: HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama models: %w", err)
+	}
+
+	models := make([]string, 0, len(response.Models))
+	for _, model := range response.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// ContextWindow returns the num_ctx a model is configured with in its
+// Modelfile. Without one Ollama uses its server default, which isn't
+// exposed over the API.
+func (b *ollamaBackend) ContextWindow(ctx context.Context, model string) (int, error) {
+	b.mu.Lock()
+	window, ok := b.windows[model]
+	b.mu.Unlock()
+	if ok {
+		return window, nil
+	}
+
+	resp, err := b.post(ctx, "/api/show", map[string]interface{}{
+		"model": model,
+		"name":  model, // Ollama before 0.4
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to show model: HTTP %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Parameters string `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode model details: %w", err)
+	}
+
+	for _, line := range strings.Split(response.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			window, _ = strconv.Atoi(fields[1])
+		}
+	}
+
+	b.mu.Lock()
+	b.windows[model] = window
+	b.mu.Unlock()
+
+	if window <= 0 {
+		return 0, fmt.Errorf("model %s has no num_ctx parameter", model)
+	}
+	return window, nil
+}
+
+// Pull downloads a model into Ollama, reporting each progress line
+func (b *ollamaBackend) Pull(ctx context.Context, model string, onProgress func(PullProgress)) error {
+	resp, err := b.post(ctx, "/api/pull", map[string]interface{}{
+		"model":  model,
+		"name":   model, // Ollama before 0.4
+		"stream": true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to load model: HTTP %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var line struct {
+			Status    string `json:"status"`
+			Digest    string `json:"digest"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("failed to decode pull progress: %w", err)
+		}
+		if line.Error != "" {
+			return fmt.Errorf("failed to pull model: %s", line.Error)
+		}
+
+		onProgress(PullProgress{
+			Status:    line.Status,
+			Digest:    line.Digest,
+			Total:     line.Total,
+			Completed: line.Completed,
+		})
+
+		if line.Status == "success" {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read pull progress: %w", err)
+	}
+
+	return fmt.Errorf("model pull ended before it finished")
+}
+
+// CreateModel uploads a GGUF file as a blob and creates a model from it. The
+// request carries both the Modelfile text read by Ollama before 0.5.5 and
+// the fields that replaced it.
+func (b *ollamaBackend) CreateModel(ctx context.Context, name string, modelfile Modelfile,
+	onProgress func(PullProgress)) error {
+	digest := modelfile.Digest
+	if digest == "" {
+		onProgress(PullProgress{Status: "hashing model file"})
+		var err error
+		if digest, err = fileSHA256(modelfile.From); err != nil {
+			return err
+		}
+	}
+	digest = "sha256:" + strings.ToLower(digest)
+
+	if err := b.pushBlob(ctx, modelfile.From, digest, onProgress); err != nil {
+		return err
+	}
+
+	request := map[string]interface{}{
+		"model":     name,
+		"name":      name, // Ollama before 0.4
+		"modelfile": modelfile.render("@" + digest),
+		"files":     map[string]string{filepath.Base(modelfile.From): digest},
+		"stream":    true,
+	}
+	if modelfile.Template != "" {
+		request["template"] = modelfile.Template
+	}
+	if modelfile.System != "" {
+		request["system"] = modelfile.System
+	}
+	if len(modelfile.parameterLines()) > 0 {
+		request["parameters"] = modelfile.Parameters
+	}
+
+	resp, err := b.post(ctx, "/api/create", request)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var response struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("failed to create model: HTTP %d %s", resp.StatusCode, response.Error)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var line struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("failed to decode create progress: %w", err)
+		}
+		if line.Error != "" {
+			return fmt.Errorf("failed to create model: %s", line.Error)
+		}
+
+		onProgress(PullProgress{Status: line.Status})
+
+		if line.Status == "success" {
+			// A re-created model may have a different num_ctx
+			b.mu.Lock()
+			delete(b.windows, name)
+			delete(b.windows, name+":latest")
+			b.mu.Unlock()
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read create progress: %w", err)
+	}
+
+	return fmt.Errorf("model creation ended before it finished")
+}
+
+// pushBlob uploads a file to Ollama unless it already has a blob with that digest
+func (b *ollamaBackend) pushBlob(ctx context.Context, path, digest string, onProgress func(PullProgress)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, b.baseURL+"/api/blobs/"+digest, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open model file: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	var sent int64
+	body := &progressReader{r: f, onRead: func(n int) {
+		sent += int64(n)
+		onProgress(PullProgress{Status: "uploading model file", Digest: digest, Total: stat.Size(), Completed: sent})
+	}}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/api/blobs/"+digest, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+
+	resp, err = b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload model file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload model file: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.onRead(n)
+	}
+	return n, err
+}
+
+func (b *ollamaBackend) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	jsonBody, _ := json.Marshal(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+path, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return b.client.Do(req)
+}
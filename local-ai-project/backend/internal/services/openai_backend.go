@@ -0,0 +1,387 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"local-ai-project/backend/internal/config"
+	"local-ai-project/backend/pkg/types"
+)
+
+// openAIBackend talks to any server with an OpenAI-compatible API, such as
+// the llama.cpp server, vLLM or LM Studio.
+type openAIBackend struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+
+	mu          sync.Mutex
+	noTokenizer bool           // the server has no /tokenize endpoint
+	windows     map[string]int // context window per model, 0 when not reported
+}
+
+func newOpenAIBackend(cfg *config.Config) *openAIBackend {
+	return &openAIBackend{
+		baseURL: strings.TrimRight(cfg.OpenAIBaseURL, "/"),
+		apiKey:  cfg.OpenAIAPIKey,
+		client:  &http.Client{},
+		windows: make(map[string]int),
+	}
+}
+
+// openAIResponse is a completion response or one streamed chunk of it
+type openAIResponse struct {
+	Choices []struct {
+		Text    string `json:"text"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (r openAIResponse) text() string {
+	var text strings.Builder
+	for _, choice := range r.Choices {
+		text.WriteString(choice.Text + choice.Message.Content + choice.Delta.Content)
+	}
+	return text.String()
+}
+
+func (b *openAIBackend) Generate(ctx context.Context, model, prompt string, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	reqBody := openAIRequestBody(model, opts, onToken != nil)
+	reqBody["prompt"] = prompt
+	return b.complete(ctx, b.baseURL+"/completions", reqBody, onToken)
+}
+
+func (b *openAIBackend) Chat(ctx context.Context, model string, messages []types.ChatMessage, opts types.GenerationOptions,
+	onToken func(string) error) (string, GenerationStats, error) {
+	chatMessages := make([]map[string]string, 0, len(messages))
+	for _, msg := range messages {
+		chatMessages = append(chatMessages, map[string]string{
+			"role":    msg.Role,
+			"content": msg.Content,
+		})
+	}
+
+	reqBody := openAIRequestBody(model, opts, onToken != nil)
+	reqBody["messages"] = chatMessages
+	return b.complete(ctx, b.baseURL+"/chat/completions", reqBody, onToken)
+}
+
+// openAIRequestBody maps the generation options to OpenAI parameters. top_k
+// and repeat_penalty aren't part of the OpenAI API but are accepted by the
+// local servers this backend is meant for. num_ctx is set when the server
+// starts and can't be changed per request.
+func openAIRequestBody(model string, opts types.GenerationOptions, stream bool) map[string]interface{} {
+	reqBody := map[string]interface{}{
+		"model":  model,
+		"stream": stream,
+	}
+	if stream {
+		reqBody["stream_options"] = map[string]bool{"include_usage": true}
+	}
+	if opts.Temperature != nil {
+		reqBody["temperature"] = *opts.Temperature
+	}
+	if opts.TopP != nil {
+		reqBody["top_p"] = *opts.TopP
+	}
+	if opts.TopK != nil {
+		reqBody["top_k"] = *opts.TopK
+	}
+	if opts.RepeatPenalty != nil {
+		reqBody["repeat_penalty"] = *opts.RepeatPenalty
+	}
+	if opts.NumPredict != nil {
+		reqBody["max_tokens"] = *opts.NumPredict
+	}
+	if opts.Seed != nil {
+		reqBody["seed"] = *opts.Seed
+	}
+	if len(opts.Stop) > 0 {
+		reqBody["stop"] = opts.Stop
+	}
+	return reqBody
+}
+
+// complete posts a completion request and reads the single response or,
+// when streaming, the Server-Sent Events.
+func (b *openAIBackend) complete(ctx context.Context, url string, reqBody map[string]interface{},
+	onToken func(string) error) (string, GenerationStats, error) {
+	var stats GenerationStats
+
+	resp, err := b.do(ctx, http.MethodPost, url, reqBody)
+	if err != nil {
+		return "", stats, fmt.Errorf("failed to generate response: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", stats, fmt.Errorf("AI service error: %s", openAIErrorMessage(resp))
+	}
+
+	if onToken == nil {
+		var response openAIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return "", stats, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if response.Usage != nil {
+			stats.PromptTokens = response.Usage.PromptTokens
+			stats.CompletionTokens = response.Usage.CompletionTokens
+		}
+		return response.text(), stats, nil
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var text bytes.Buffer
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return text.String(), stats, nil
+		}
+
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", stats, fmt.Errorf("failed to decode stream: %w", err)
+		}
+		if chunk.Error != nil {
+			return "", stats, fmt.Errorf("AI service error: %s", chunk.Error.Message)
+		}
+
+		if token := chunk.text(); token != "" {
+			text.WriteString(token)
+			if err := onToken(token); err != nil {
+				return "", stats, err
+			}
+		}
+
+		if chunk.Usage != nil {
+			stats.PromptTokens = chunk.Usage.PromptTokens
+			stats.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", stats, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return "", stats, fmt.Errorf("AI service closed the stream early")
+}
+
+func (b *openAIBackend) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	resp, err := b.do(ctx, http.MethodPost, b.baseURL+"/embeddings", map[string]interface{}{
+		"model": model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to embedding server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding service error: %s", openAIErrorMessage(resp))
+	}
+
+	var response struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+
+	// Results carry their input index and aren't guaranteed to be in order
+	vectors := make([][]float32, len(texts))
+	for _, item := range response.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding server returned an unknown index %d", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+func (b *openAIBackend) ListModels(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := b.do(ctx, http.MethodGet, b.baseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to model server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list models: %s", openAIErrorMessage(resp))
+	}
+
+	var response struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	models := make([]string, 0, len(response.Data))
+	for _, model := range response.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// CountTokens uses the /tokenize endpoint of the llama.cpp server and vLLM,
+// which sits next to the /v1 API.
+func (b *openAIBackend) CountTokens(ctx context.Context, model, text string) (int, error) {
+	b.mu.Lock()
+	noTokenizer := b.noTokenizer
+	b.mu.Unlock()
+	if noTokenizer {
+		return 0, fmt.Errorf("model server has no tokenizer endpoint")
+	}
+
+	// llama.cpp reads content, vLLM reads model and prompt
+	resp, err := b.do(ctx, http.MethodPost, b.rootURL()+"/tokenize", map[string]interface{}{
+		"model":   model,
+		"prompt":  text,
+		"content": text,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to model server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		b.mu.Lock()
+		b.noTokenizer = true
+		b.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to tokenize: %s", openAIErrorMessage(resp))
+	}
+
+	var response struct {
+		Tokens []json.RawMessage `json:"tokens"`
+		Count  *int              `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode tokens: %w", err)
+	}
+	if response.Count != nil {
+		return *response.Count, nil
+	}
+	return len(response.Tokens), nil
+}
+
+// ContextWindow reads max_model_len from the model list, which vLLM reports
+func (b *openAIBackend) ContextWindow(ctx context.Context, model string) (int, error) {
+	b.mu.Lock()
+	window, ok := b.windows[model]
+	b.mu.Unlock()
+	if ok {
+		return contextWindowResult(model, window)
+	}
+
+	resp, err := b.do(ctx, http.MethodGet, b.baseURL+"/models", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to model server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to list models: %s", openAIErrorMessage(resp))
+	}
+
+	var response struct {
+		Data []struct {
+			ID          string `json:"id"`
+			MaxModelLen int    `json:"max_model_len"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, fmt.Errorf("failed to decode models: %w", err)
+	}
+
+	// The list covers every model, remember them all
+	b.mu.Lock()
+	b.windows[model] = 0
+	for _, m := range response.Data {
+		b.windows[m.ID] = m.MaxModelLen
+	}
+	window = b.windows[model]
+	b.mu.Unlock()
+
+	return contextWindowResult(model, window)
+}
+
+func contextWindowResult(model string, window int) (int, error) {
+	if window <= 0 {
+		return 0, fmt.Errorf("model server doesn't report the context window of %s", model)
+	}
+	return window, nil
+}
+
+// rootURL is the server address without the /v1 API prefix
+func (b *openAIBackend) rootURL() string {
+	return strings.TrimSuffix(b.baseURL, "/v1")
+}
+
+func (b *openAIBackend) do(ctx context.Context, method, url string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonBody, _ := json.Marshal(body)
+		reader = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+
+	return b.client.Do(req)
+}
+
+// openAIErrorMessage returns the error message of a failed response, or the
+// HTTP status if the body has none.
+func openAIErrorMessage(resp *http.Response) string {
+	var response openAIResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response); err == nil &&
+		response.Error != nil && response.Error.Message != "" {
+		return response.Error.Message
+	}
+	return fmt.Sprintf("HTTP %d", resp.StatusCode)
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var (
+	ErrPDFEncrypted = errors.New("PDF is encrypted and cannot be read without a password")
+	ErrPDFNoText    = errors.New("PDF contains no extractable text (scanned or image-only pages)")
+)
+
+// extractPDFText reads every page of a PDF and records where each page
+// starts in the returned text. The PDF library panics on malformed files,
+// when opening them as well as when reading pages, so that is turned into
+// an error.
+func extractPDFText(filePath string) (result *extractedText, err error) {
+	pageNumber := 0
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			if pageNumber > 0 {
+				err = fmt.Errorf("malformed PDF at page %d: %v", pageNumber, r)
+			} else {
+				err = fmt.Errorf("malformed PDF: %v", r)
+			}
+		}
+	}()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := pdf.NewReader(file, info.Size())
+	if err != nil {
+		if errors.Is(err, pdf.ErrInvalidPassword) {
+			return nil, ErrPDFEncrypted
+		}
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	var content strings.Builder
+	result = &extractedText{}
+	offset := 0
+
+	for pageNumber = 1; pageNumber <= reader.NumPage(); pageNumber++ {
+		page := reader.Page(pageNumber)
+		if page.V.IsNull() {
+			// An empty page keeps the numbering of the pages after it
+			result.Pages = append(result.Pages, offset)
+			continue
+		}
+
+		text := pdfPageText(page)
+		if content.Len() > 0 {
+			content.WriteString("\n\n")
+			offset += 2
+		}
+		result.Pages = append(result.Pages, offset)
+		content.WriteString(text)
+		offset += utf8.RuneCountInString(text)
+	}
+
+	result.Content = content.String()
+	if strings.TrimSpace(result.Content) == "" {
+		return nil, ErrPDFNoText
+	}
+
+	return result, nil
+}
+
+// pdfPageText rebuilds the lines of a page from positioned glyphs
+func pdfPageText(page pdf.Page) string {
+	var b strings.Builder
+	var lastY, lastEnd, lastSize float64
+	first := true
+
+	for _, glyph := range page.Content().Text {
+		if glyph.S == "" {
+			continue
+		}
+
+		size := glyph.FontSize
+		if size <= 0 {
+			size = 10
+		}
+
+		if !first {
+			switch {
+			case math.Abs(glyph.Y-lastY) > size*0.5:
+				// New line, with a blank line for large vertical gaps
+				b.WriteString("\n")
+				if math.Abs(glyph.Y-lastY) > math.Max(size, lastSize)*1.8 {
+					b.WriteString("\n")
+				}
+			case glyph.X-lastEnd > size*0.15 && !endsWithSpace(&b) && !isSpaceString(glyph.S):
+				// Gap between words on the same line
+				b.WriteString(" ")
+			}
+		}
+
+		b.WriteString(glyph.S)
+		lastY, lastEnd, lastSize = glyph.Y, glyph.X+glyph.W, size
+		first = false
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func endsWithSpace(b *strings.Builder) bool {
+	s := b.String()
+	if s == "" {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return unicode.IsSpace(r)
+}
+
+func isSpaceString(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
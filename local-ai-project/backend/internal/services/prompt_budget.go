@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// Tokenizer is implemented by backends that can count tokens with the
+// model's own tokenizer.
+type Tokenizer interface {
+	CountTokens(ctx context.Context, model, text string) (int, error)
+}
+
+// ContextWindower is implemented by backends that know a model's context size
+type ContextWindower interface {
+	ContextWindow(ctx context.Context, model string) (int, error)
+}
+
+// A source is shortened rather than dropped if at least this many tokens fit
+const minShortenedTokens = 64
+
+// ErrPromptTooLong is returned when the question alone doesn't fit the context window
+var ErrPromptTooLong = errors.New("question is too long for the model's context window")
+
+// tokenCounter counts tokens with the backend's tokenizer, falling back to
+// the estimate for the rest of the request if the tokenizer fails.
+type tokenCounter struct {
+	ctx       context.Context
+	tokenizer Tokenizer
+	model     string
+}
+
+func newTokenCounter(ctx context.Context, backend LLMBackend, model string) *tokenCounter {
+	tokenizer, _ := backend.(Tokenizer)
+	return &tokenCounter{ctx: ctx, tokenizer: tokenizer, model: model}
+}
+
+func (c *tokenCounter) count(text string) int {
+	if c.tokenizer != nil {
+		if n, err := c.tokenizer.CountTokens(c.ctx, c.model, text); err == nil {
+			return n
+		}
+		c.tokenizer = nil
+	}
+	return estimateTokens(text)
+}
+
+// fitContext fills the context with as many sources as the token budget
+// allows, best-ranked first. The first source that doesn't fit is shortened
+// if enough room is left, it and all lower-ranked sources are reported as
+// dropped or truncated. It returns the included sources by block number.
+func fitContext(counter *tokenCounter, chunks []types.DocumentChunk, wikiResults []types.WikiResult,
+	tokenBudget int) (string, []types.Citation, []types.DroppedSource) {
+	var context strings.Builder
+	var sources []types.Citation
+	var dropped []types.DroppedSource
+	remaining := tokenBudget
+	full := false
+
+	for _, block := range contextBlocks(chunks, wikiResults) {
+		number := len(sources) + 1
+		text := formatContextBlock(number, block)
+		tokens := counter.count(text)
+
+		if !full && tokens <= remaining {
+			remaining -= tokens
+			context.WriteString(text)
+			sources = append(sources, block.Source)
+			continue
+		}
+
+		source := droppedSource(block.Source, tokens)
+		if !full && remaining >= minShortenedTokens {
+			if shortened, used := shortenBlock(counter, number, block, remaining); shortened != "" {
+				remaining -= used
+				context.WriteString(shortened)
+				sources = append(sources, block.Source)
+				source.Truncated = true
+			}
+		}
+		full = true
+		dropped = append(dropped, source)
+	}
+
+	return context.String(), sources, dropped
+}
+
+func droppedSource(source types.Citation, tokens int) types.DroppedSource {
+	dropped := types.DroppedSource{
+		Type:       source.SourceType,
+		Name:       source.Name,
+		DocumentID: source.DocumentID,
+		Page:       source.Page,
+		Tokens:     tokens,
+	}
+	if source.ChunkIndex != nil {
+		dropped.ChunkIndex = *source.ChunkIndex
+	}
+	return dropped
+}
+
+// shortenBlock cuts a block's text at a word boundary so the formatted
+// block fits the budget. It returns the block and its token count, or an
+// empty string if no useful part fits.
+func shortenBlock(counter *tokenCounter, number int, block contextBlock, budget int) (string, int) {
+	runes := []rune(strings.TrimSpace(block.Text))
+	total := counter.count(formatContextBlock(number, block))
+
+	// Start from the proportional length and tighten until it fits
+	keep := len(runes) * budget / max(total, 1)
+	for attempt := 0; attempt < 5 && keep > 0; attempt++ {
+		cut := keep
+		for cut > 0 && !unicode.IsSpace(runes[cut-1]) {
+			cut--
+		}
+		if cut == 0 {
+			break
+		}
+
+		shortened := block
+		shortened.Text = strings.TrimSpace(string(runes[:cut])) + " …"
+		text := formatContextBlock(number, shortened)
+		if tokens := counter.count(text); tokens <= budget {
+			return text, tokens
+		}
+		keep = keep * 9 / 10
+	}
+
+	return "", 0
+}
+
+// contextWindow returns the number of tokens the model sees per request
+func (s *AIService) contextWindow(ctx context.Context, model string, opts types.GenerationOptions) int {
+	if opts.NumCtx != nil {
+		return *opts.NumCtx
+	}
+	if windower, ok := s.backend.(ContextWindower); ok {
+		if n, err := windower.ContextWindow(ctx, model); err == nil && n > 0 {
+			return n
+		}
+	}
+	return s.config.ContextWindow
+}
+
+// sourceBudget returns the tokens left for sources once the fixed part of
+// the prompt and the room reserved for the answer are taken from the
+// model's context window, up to the configured context budget.
+func (s *AIService) sourceBudget(ctx context.Context, model string, opts types.GenerationOptions,
+	fixedTokens int) (int, error) {
+	window := s.contextWindow(ctx, model, opts)
+
+	// Leave at least half the window for the prompt
+	reserve := window / 2
+	if opts.NumPredict != nil && *opts.NumPredict < reserve {
+		reserve = *opts.NumPredict
+	}
+
+	budget := window - reserve - fixedTokens
+	if budget < 0 {
+		return 0, fmt.Errorf("%w of %d tokens", ErrPromptTooLong, window)
+	}
+	if s.config.ContextTokenBudget > 0 {
+		budget = min(budget, s.config.ContextTokenBudget)
+	}
+	return budget, nil
+}
+
+// assemblePrompt builds the prompt so that the template, the question and
+// the room reserved for the answer always fit the model's context window.
+// Sources get the rest, up to the configured context budget.
+func (s *AIService) assemblePrompt(ctx context.Context, model, query string, chunks []types.DocumentChunk,
+	wikiResults []types.WikiResult, opts types.GenerationOptions,
+	prompt *Prompt) (string, []types.Citation, []types.DroppedSource, error) {
+	counter := newTokenCounter(ctx, s.backend, model)
+
+	base, err := prompt.render(defaultQueryPrompt, PromptVars{Question: query})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	budget, err := s.sourceBudget(ctx, model, opts, counter.count(base))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	context, sources, dropped := fitContext(counter, chunks, wikiResults, budget)
+	rendered, err := prompt.render(defaultQueryPrompt, PromptVars{Question: query, Sources: context})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return rendered, sources, dropped, nil
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+var (
+	ErrPromptNotFound = errors.New("prompt template not found")
+	ErrPromptExists   = errors.New("prompt template already exists")
+	ErrInvalidPrompt  = errors.New("invalid prompt template")
+)
+
+var promptName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// PromptVars are the variables a prompt template can use. History is the
+// summary of the earlier conversation and only set in chat sessions. In
+// chat sessions Question is empty, the template is the system message and
+// the question is sent as the user message after it.
+type PromptVars struct {
+	Question string
+	Sources  string
+	History  string
+	Language string
+}
+
+// Prompt is the prompt template selected for a request
+type Prompt struct {
+	Template *template.Template // nil selects the built-in prompt
+	Language string
+}
+
+// render executes the selected template, or fallback if none was selected
+func (p *Prompt) render(fallback *template.Template, vars PromptVars) (string, error) {
+	tmpl := fallback
+	if p != nil {
+		vars.Language = p.Language
+		if p.Template != nil {
+			tmpl = p.Template
+		}
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, vars); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// PromptService stores named prompt templates. Saving a template under an
+// existing name adds a new version, earlier versions stay selectable.
+type PromptService struct {
+	db *sql.DB
+}
+
+func NewPromptService(db *sql.DB) *PromptService {
+	return &PromptService{db: db}
+}
+
+// ListPrompts returns the latest version of every template
+func (s *PromptService) ListPrompts() ([]types.PromptTemplate, error) {
+	return s.query(`SELECT id, name, version, description, template, created_at FROM prompt_templates p
+					WHERE version = (SELECT MAX(version) FROM prompt_templates WHERE name = p.name)
+					ORDER BY name`)
+}
+
+// ListVersions returns all versions of a template, newest first
+func (s *PromptService) ListVersions(name string) ([]types.PromptTemplate, error) {
+	versions, err := s.query(`SELECT id, name, version, description, template, created_at FROM prompt_templates
+							  WHERE name = ? ORDER BY version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromptNotFound, name)
+	}
+	return versions, nil
+}
+
+// GetPrompt returns a version of a template, or the latest if version is 0
+func (s *PromptService) GetPrompt(name string, version int) (*types.PromptTemplate, error) {
+	var prompt types.PromptTemplate
+	err := s.db.QueryRow(`SELECT id, name, version, description, template, created_at FROM prompt_templates
+						  WHERE name = ? AND (version = ? OR ? = 0) ORDER BY version DESC LIMIT 1`,
+		name, version, version).
+		Scan(&prompt.ID, &prompt.Name, &prompt.Version, &prompt.Description, &prompt.Template, &prompt.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			if version > 0 {
+				return nil, fmt.Errorf("%w: %s version %d", ErrPromptNotFound, name, version)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrPromptNotFound, name)
+		}
+		return nil, err
+	}
+	return &prompt, nil
+}
+
+// CreatePrompt stores the first version of a new template
+func (s *PromptService) CreatePrompt(req types.PromptTemplateRequest) (*types.PromptTemplate, error) {
+	if !promptName.MatchString(req.Name) {
+		return nil, fmt.Errorf("%w: name must be 1 to 64 letters, digits, dots, dashes or underscores", ErrInvalidPrompt)
+	}
+	return s.saveVersion(req, false)
+}
+
+// UpdatePrompt stores a new version of an existing template
+func (s *PromptService) UpdatePrompt(name string, req types.PromptTemplateRequest) (*types.PromptTemplate, error) {
+	req.Name = name
+	return s.saveVersion(req, true)
+}
+
+func (s *PromptService) saveVersion(req types.PromptTemplateRequest, update bool) (*types.PromptTemplate, error) {
+	if _, err := parsePrompt(req.Name, req.Template); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var latest int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM prompt_templates WHERE name = ?`, req.Name).
+		Scan(&latest); err != nil {
+		return nil, err
+	}
+	if update && latest == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromptNotFound, req.Name)
+	}
+	if !update && latest > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPromptExists, req.Name)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO prompt_templates (name, version, description, template) VALUES (?, ?, ?, ?)`,
+		req.Name, latest+1, strings.TrimSpace(req.Description), req.Template); err != nil {
+		return nil, fmt.Errorf("failed to store prompt template: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.GetPrompt(req.Name, latest+1)
+}
+
+// DeletePrompt removes a template with all of its versions
+func (s *PromptService) DeletePrompt(name string) error {
+	result, err := s.db.Exec(`DELETE FROM prompt_templates WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete prompt template: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrPromptNotFound, name)
+	}
+	return nil
+}
+
+// Prompt selects the template for a request. An empty name keeps the
+// built-in prompt.
+func (s *PromptService) Prompt(name string, version int, language string) (*Prompt, error) {
+	prompt := &Prompt{Language: strings.TrimSpace(language)}
+	if name == "" {
+		return prompt, nil
+	}
+
+	stored, err := s.GetPrompt(name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt.Template, err = parsePrompt(stored.Name, stored.Template)
+	if err != nil {
+		return nil, err
+	}
+	return prompt, nil
+}
+
+func (s *PromptService) query(query string, args ...interface{}) ([]types.PromptTemplate, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prompts := []types.PromptTemplate{}
+	for rows.Next() {
+		var prompt types.PromptTemplate
+		if err := rows.Scan(&prompt.ID, &prompt.Name, &prompt.Version, &prompt.Description, &prompt.Template,
+			&prompt.CreatedAt); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, prompt)
+	}
+
+	return prompts, rows.Err()
+}
+
+// parsePrompt parses a template and test-renders it, so templates that use
+// unknown variables are rejected when they are saved.
+func parsePrompt(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrompt, err)
+	}
+
+	sample := PromptVars{Question: "question", Sources: "sources", History: "history", Language: "language"}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrompt, err)
+	}
+	return tmpl, nil
+}
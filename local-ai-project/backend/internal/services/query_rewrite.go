@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"local-ai-project/backend/pkg/types"
+)
+
+// Earlier chat turns the rewrite sees to resolve references like "it"
+const rewriteHistoryTurns = 6
+
+const rewritePrompt = `Rewrite the user's latest question as a standalone search query that can be
+understood without the conversation. Use the names and terms an encyclopedia
+would use as article titles. Then write %d paraphrases of the query with
+different wording. Reply with JSON only, in this form:
+{"query": "...", "paraphrases": ["..."]}
+%s
+Question: %s
+`
+
+// RewriteQuery turns a question into a standalone search query and
+// paraphrases for retrieval. enabled and paraphrases fall back to the
+// server defaults. It returns nil if rewriting is off. If the model fails,
+// the rewrite carries the error and only the question is searched.
+func (s *AIService) RewriteQuery(model, question string, history []types.ChatMessage, enabled *bool,
+	paraphrases *int) *types.QueryRewrite {
+	if !*pick(enabled, &s.config.QueryRewrite) {
+		return nil
+	}
+	n := clampInt(*pick(paraphrases, &s.config.QueryParaphrases), 0, s.config.MaxQueryParaphrases)
+
+	rewrite, err := s.rewrite(model, question, history, n)
+	if err != nil {
+		log.Printf("Warning: query rewrite failed, searching the question as asked: %v", err)
+		rewrite = &types.QueryRewrite{Error: err.Error()}
+	}
+
+	// The rewritten query goes first, Wikipedia matches it against titles
+	seen := map[string]bool{}
+	for _, query := range append([]string{rewrite.Query, question}, rewrite.Paraphrases...) {
+		key := strings.ToLower(strings.TrimSpace(query))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		rewrite.Searched = append(rewrite.Searched, strings.TrimSpace(query))
+	}
+
+	return rewrite
+}
+
+func (s *AIService) rewrite(model, question string, history []types.ChatMessage, paraphrases int) (*types.QueryRewrite, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var conversation strings.Builder
+	if len(history) > rewriteHistoryTurns {
+		history = history[len(history)-rewriteHistoryTurns:]
+	}
+	if len(history) > 0 {
+		conversation.WriteString("\nConversation so far:\n")
+		for _, msg := range history {
+			conversation.WriteString(fmt.Sprintf("%s: %s\n", msg.Role, truncateRunes(msg.Content, 500)))
+		}
+	}
+
+	// A low temperature keeps the query close to the question
+	options := generationOptions(s.config.Generation, &types.GenerationOptions{
+		Temperature: ptr(0.2),
+		NumPredict:  ptr(256),
+	})
+
+	text, _, err := s.backend.Generate(ctx, model, fmt.Sprintf(rewritePrompt, paraphrases, conversation.String(), question),
+		options, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Models like to wrap JSON in prose or code fences
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("model did not reply with JSON")
+	}
+
+	var reply struct {
+		Query       string   `json:"query"`
+		Paraphrases []string `json:"paraphrases"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &reply); err != nil {
+		return nil, fmt.Errorf("failed to decode rewrite: %w", err)
+	}
+	if strings.TrimSpace(reply.Query) == "" {
+		return nil, fmt.Errorf("model returned an empty query")
+	}
+
+	rewrite := &types.QueryRewrite{Query: strings.TrimSpace(reply.Query), Paraphrases: []string{}}
+	for _, paraphrase := range reply.Paraphrases {
+		if paraphrase = strings.TrimSpace(paraphrase); paraphrase != "" && len(rewrite.Paraphrases) < paraphrases {
+			rewrite.Paraphrases = append(rewrite.Paraphrases, paraphrase)
+		}
+	}
+	return rewrite, nil
+}
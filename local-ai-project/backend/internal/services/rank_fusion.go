@@ -0,0 +1,55 @@
+package services
+
+import "sort"
+
+// rankedList is the output of one retriever, best hit first
+type rankedList struct {
+	hits   []scoredChunk
+	weight float64
+	vector bool
+}
+
+// reciprocalRankFusion merges ranked lists by summing weight / (k + rank)
+// for every list a chunk appears in. The fused score replaces the
+// retriever-specific scores, which aren't comparable with each other.
+func reciprocalRankFusion(k float64, lists ...rankedList) []scoredChunk {
+	if k <= 0 {
+		k = 60
+	}
+
+	fused := map[int64]*scoredChunk{}
+	var order []int64
+
+	for _, list := range lists {
+		for i, hit := range list.hits {
+			rank := i + 1
+
+			entry, ok := fused[hit.id]
+			if !ok {
+				entry = &scoredChunk{id: hit.id}
+				fused[hit.id] = entry
+				order = append(order, hit.id)
+			}
+
+			// Keep the best rank when several lists come from the same retriever
+			entry.score += list.weight / (k + float64(rank))
+			if list.vector {
+				if entry.vectorRank == 0 || rank < entry.vectorRank {
+					entry.vectorRank = rank
+				}
+			} else if entry.keywordRank == 0 || rank < entry.keywordRank {
+				entry.keywordRank = rank
+				entry.highlight = hit.highlight
+			}
+		}
+	}
+
+	result := make([]scoredChunk, 0, len(order))
+	for _, id := range order {
+		result = append(result, *fused[id])
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].score > result[j].score })
+
+	return result
+}
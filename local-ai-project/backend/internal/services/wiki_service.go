@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"local-ai-project/backend/pkg/types"
 )
@@ -118,3 +119,35 @@ func (s *WikiService) searchMultiple(query string) ([]types.WikiResult, error) {
 
 	return results, nil
 }
+
+// Pages kept when several queries are looked up
+const maxWikiResults = 5
+
+// SearchVariants looks up several queries and merges the pages found, each
+// page once in order of first appearance. It only fails if every lookup does.
+func (s *WikiService) SearchVariants(queries []string) ([]types.WikiResult, error) {
+	var results []types.WikiResult
+	var lastErr error
+	seen := map[string]bool{}
+
+	for _, query := range queries {
+		found, err := s.Search(query)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, result := range found {
+			key := strings.ToLower(result.Title)
+			if seen[key] || len(results) == maxWikiResults {
+				continue
+			}
+			seen[key] = true
+			results = append(results, result)
+		}
+	}
+
+	if len(results) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return results, nil
+}
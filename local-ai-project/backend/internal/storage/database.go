@@ -3,10 +3,13 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 func InitDB(dbPath string) (*sql.DB, error) {
@@ -16,7 +19,9 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// WAL and a busy timeout let the background workers write while
+	// requests read; immediate transactions avoid lock upgrade deadlocks
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
@@ -25,6 +30,15 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := createSearchIndex(db); err != nil {
+		// FTS5 is only compiled in with the sqlite_fts5 build tag. Without
+		// the module the sync triggers would make every chunk insert fail.
+		log.Printf("Warning: full-text search disabled (build with -tags sqlite_fts5): %v", err)
+		if err := dropSearchTriggers(db); err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
@@ -56,6 +70,170 @@ func createTables(db *sql.DB) error {
 			chunk_index INTEGER,
 			FOREIGN KEY (document_id) REFERENCES documents (id)
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_document_chunks_document_id ON document_chunks (document_id)`,
+		`CREATE TABLE IF NOT EXISTS chat_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			summary TEXT DEFAULT '',
+			summary_message_id INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			role TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (session_id) REFERENCES chat_sessions (id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_chat_messages_session_id ON chat_messages (session_id)`,
+		`CREATE TABLE IF NOT EXISTS prompt_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			description TEXT DEFAULT '',
+			template TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (name, version)
+		)`,
+	}
+
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return migrateTables(db)
+}
+
+// migrateTables adds columns introduced after the initial schema, so
+// existing databases keep working.
+func migrateTables(db *sql.DB) error {
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"documents", "status", "TEXT DEFAULT 'ready'"},
+		{"documents", "error_message", "TEXT"},
+		{"documents", "progress", "REAL DEFAULT 0"},
+		{"documents", "content_hash", "TEXT"},
+		{"documents", "version", "INTEGER DEFAULT 1"},
+		{"document_chunks", "page", "INTEGER"},
+		{"document_chunks", "embedding_model", "TEXT"},
+		{"document_chunks", "embedding_dim", "INTEGER"},
+		{"models", "source_url", "TEXT DEFAULT ''"},
+		{"models", "sha256", "TEXT DEFAULT ''"},
+		{"models", "format", "TEXT DEFAULT ''"},
+		{"models", "model_type", "TEXT DEFAULT 'unknown'"},
+		{"models", "architecture", "TEXT DEFAULT ''"},
+		{"models", "parameter_count", "INTEGER DEFAULT 0"},
+		{"models", "quantization", "TEXT DEFAULT ''"},
+		{"models", "context_length", "INTEGER DEFAULT 0"},
+		{"models", "embedding_length", "INTEGER DEFAULT 0"},
+		{"models", "chat_template", "TEXT DEFAULT ''"},
+		{"models", "error_message", "TEXT DEFAULT ''"},
+		{"models", "last_used_at", "DATETIME"},
+		{"models", "imported_as", "TEXT DEFAULT ''"},
+	}
+
+	for _, c := range columns {
+		if err := addColumnIfNotExists(db, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// Indexes on migrated columns and data fixes for rows written by older versions
+	updates := []string{
+		`CREATE INDEX IF NOT EXISTS idx_documents_content_hash ON documents (content_hash)`,
+		`UPDATE documents SET status = 'failed' WHERE status = 'error'`,
+		`UPDATE documents SET progress = 100 WHERE status = 'ready' AND progress = 0`,
+	}
+
+	for _, update := range updates {
+		if _, err := db.Exec(update); err != nil {
+			return err
+		}
+	}
+
+	// Concurrent uploads of the same content must not both be stored as the
+	// same version. Failed documents are left out since re-uploading retries
+	// them. Databases that already hold such duplicates keep working
+	// without the constraint.
+	_, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_documents_content_version
+		ON documents (content_hash, version) WHERE status != 'failed'`)
+	if err != nil {
+		log.Printf("Warning: failed to create unique content hash index: %v", err)
+	}
+
+	return nil
+}
+
+// IsUniqueViolation reports whether err is a failed UNIQUE constraint
+func IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) error {
+	exists, err := columnExists(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// createSearchIndex sets up an FTS5 index over chunk text that triggers
+// keep in sync with document_chunks.
+func createSearchIndex(db *sql.DB) error {
+	inSync := FullTextSearchEnabled(db)
+
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS document_chunks_fts USING fts5(
+			content,
+			content='document_chunks',
+			content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS document_chunks_fts_insert AFTER INSERT ON document_chunks BEGIN
+			INSERT INTO document_chunks_fts (rowid, content) VALUES (new.id, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS document_chunks_fts_delete AFTER DELETE ON document_chunks BEGIN
+			INSERT INTO document_chunks_fts (document_chunks_fts, rowid, content) VALUES ('delete', old.id, old.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS document_chunks_fts_update AFTER UPDATE OF content ON document_chunks BEGIN
+			INSERT INTO document_chunks_fts (document_chunks_fts, rowid, content) VALUES ('delete', old.id, old.content);
+			INSERT INTO document_chunks_fts (rowid, content) VALUES (new.id, new.content);
+		END`,
 	}
 
 	for _, query := range queries {
@@ -64,9 +242,32 @@ func createTables(db *sql.DB) error {
 		}
 	}
 
+	// Index chunks stored while the triggers were missing
+	if !inSync {
+		if _, err := db.Exec(`INSERT INTO document_chunks_fts (document_chunks_fts) VALUES ('rebuild')`); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func dropSearchTriggers(db *sql.DB) error {
+	for _, trigger := range []string{"document_chunks_fts_insert", "document_chunks_fts_delete", "document_chunks_fts_update"} {
+		if _, err := db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FullTextSearchEnabled reports whether the FTS5 chunk index is kept in sync
+func FullTextSearchEnabled(db *sql.DB) bool {
+	var name string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'trigger' AND name = 'document_chunks_fts_insert'`).Scan(&name)
+	return err == nil
+}
+
 func createDirIfNotExists(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return os.MkdirAll(path, 0755)
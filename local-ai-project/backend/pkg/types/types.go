@@ -18,14 +18,33 @@ type WikiResult struct {
 
 // Document represents an uploaded document
 type Document struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	Type       string `json:"type"`
-	Size       int64  `json:"size"`
-	UploadDate string `json:"uploadDate"`
-	Status     string `json:"status"`
-	Chunks     int    `json:"chunks,omitempty"`
-	Embeddings bool   `json:"embeddings,omitempty"`
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	Size       int64   `json:"size"`
+	UploadDate string  `json:"uploadDate"`
+	Status     string  `json:"status"`
+	Progress   float64 `json:"progress"`
+	Chunks     int     `json:"chunks,omitempty"`
+	Embeddings bool    `json:"embeddings,omitempty"`
+	Error      string  `json:"error,omitempty"`
+
+	ContentHash string `json:"contentHash,omitempty"`
+	Version     int    `json:"version,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"`
+}
+
+// DocumentChunk represents a retrieved piece of a document
+type DocumentChunk struct {
+	DocumentID   int     `json:"documentId"`
+	DocumentName string  `json:"documentName"`
+	ChunkIndex   int     `json:"chunkIndex"`
+	Page         int     `json:"page,omitempty"`
+	Content      string  `json:"content"`
+	Highlight    string  `json:"highlight,omitempty"`
+	Score        float64 `json:"score"`
+	VectorRank   int     `json:"vectorRank,omitempty"`
+	KeywordRank  int     `json:"keywordRank,omitempty"`
 }
 
 // Model represents an AI model
@@ -36,7 +55,24 @@ type Model struct {
 	Status           string  `json:"status"`
 	DownloadProgress float64 `json:"downloadProgress,omitempty"`
 	Description      string  `json:"description,omitempty"`
-	ModelType        string  `json:"modelType"`
+	Error            string  `json:"error,omitempty"`
+	ModelType        string  `json:"modelType"` // chat, embedding or unknown
+
+	// Read from the GGUF header of downloaded model files
+	SizeBytes       int64  `json:"sizeBytes,omitempty"`
+	Format          string `json:"format,omitempty"`
+	Architecture    string `json:"architecture,omitempty"`
+	ParameterCount  int64  `json:"parameterCount,omitempty"`
+	Quantization    string `json:"quantization,omitempty"`
+	ContextLength   int    `json:"contextLength,omitempty"`
+	EmbeddingLength int    `json:"embeddingLength,omitempty"`
+	ChatTemplate    string `json:"chatTemplate,omitempty"`
+
+	// Recorded in the models table
+	SourceURL  string `json:"sourceUrl,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	LastUsedAt string `json:"lastUsedAt,omitempty"`
+	ImportedAs string `json:"importedAs,omitempty"` // backend model created from the file
 }
 
 // QueryRequest represents a query request
@@ -46,23 +82,199 @@ type QueryRequest struct {
 	IncludeWiki      bool   `json:"include_wiki"`
 	IncludeDocuments bool   `json:"include_documents"`
 	MaxSources       int    `json:"max_sources,omitempty"`
+
+	Options *GenerationOptions `json:"options,omitempty"`
+
+	// Prompt names a stored prompt template, the latest version unless
+	// PromptVersion is set
+	Prompt        string `json:"prompt,omitempty"`
+	PromptVersion int    `json:"prompt_version,omitempty"`
+	Language      string `json:"language,omitempty"`
+
+	// Rewrite turns the question into a standalone search query plus
+	// paraphrases before retrieval, the server default if unset
+	Rewrite     *bool `json:"rewrite,omitempty"`
+	Paraphrases *int  `json:"paraphrases,omitempty"`
+}
+
+// QueryRewrite represents the search queries generated from a question
+type QueryRewrite struct {
+	Query       string   `json:"query"`
+	Paraphrases []string `json:"paraphrases"`
+	Searched    []string `json:"searched"` // every distinct query that was retrieved
+	Error       string   `json:"error,omitempty"`
+}
+
+// GenerationOptions represents sampling parameters passed to Ollama's options.
+// Unset fields use the server defaults.
+type GenerationOptions struct {
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	NumCtx        *int     `json:"num_ctx,omitempty"`
+	NumPredict    *int     `json:"num_predict,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+}
+
+// QuerySources lists the context a response was generated from
+type QuerySources struct {
+	Documents []Document      `json:"documents"`
+	Chunks    []DocumentChunk `json:"chunks"`
+	Wiki      []WikiResult    `json:"wiki"`
 }
 
 // QueryResponse represents a query response
 type QueryResponse struct {
-	Response string `json:"response"`
-	Sources  struct {
-		Documents []Document   `json:"documents"`
-		Wiki      []WikiResult `json:"wiki"`
-	} `json:"sources"`
-	ModelUsed      string  `json:"modelUsed"`
-	ProcessingTime float64 `json:"processingTime"`
+	Response       string       `json:"response"`
+	Sources        QuerySources `json:"sources"`
+	ModelUsed      string       `json:"modelUsed"`
+	ProcessingTime float64      `json:"processingTime"`
+
+	Citations        []Citation      `json:"citations"`
+	InvalidCitations []int           `json:"invalidCitations,omitempty"`
+	DroppedSources   []DroppedSource `json:"droppedSources,omitempty"`
+	Rewrites         *QueryRewrite   `json:"rewrites,omitempty"`
+}
+
+// Citation represents a [n] marker in a response and the source it refers to.
+// Start and End are character offsets of the cited sentence in the response.
+type Citation struct {
+	Number     int    `json:"number"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Text       string `json:"text"`
+	SourceType string `json:"sourceType"` // document or wiki
+	Name       string `json:"name"`
+	DocumentID int    `json:"documentId,omitempty"`
+	ChunkIndex *int   `json:"chunkIndex,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// DroppedSource represents a retrieved source that was left out of the
+// prompt, or shortened if Truncated is set, to fit the context window
+type DroppedSource struct {
+	Type       string `json:"type"` // document or wiki
+	Name       string `json:"name"`
+	DocumentID int    `json:"documentId,omitempty"`
+	ChunkIndex int    `json:"chunkIndex,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	Tokens     int    `json:"tokens"`
+	Truncated  bool   `json:"truncated"`
+}
+
+// QueryStreamDone is the final event of a streamed query
+type QueryStreamDone struct {
+	ModelUsed        string  `json:"modelUsed"`
+	ProcessingTime   float64 `json:"processingTime"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+
+	Citations        []Citation      `json:"citations"`
+	InvalidCitations []int           `json:"invalidCitations,omitempty"`
+	DroppedSources   []DroppedSource `json:"droppedSources,omitempty"`
+	Rewrites         *QueryRewrite   `json:"rewrites,omitempty"`
+}
+
+// ChatSession represents a persisted conversation
+type ChatSession struct {
+	ID        int           `json:"id"`
+	Title     string        `json:"title"`
+	CreatedAt string        `json:"createdAt"`
+	UpdatedAt string        `json:"updatedAt"`
+	Messages  []ChatMessage `json:"messages,omitempty"`
+}
+
+// ChatMessage represents one turn of a conversation
+type ChatMessage struct {
+	ID        int    `json:"id,omitempty"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// ChatRequest represents a new user message in a chat session
+type ChatRequest struct {
+	Message          string `json:"message" binding:"required"`
+	ModelName        string `json:"model_name"`
+	IncludeWiki      bool   `json:"include_wiki"`
+	IncludeDocuments bool   `json:"include_documents"`
+	MaxSources       int    `json:"max_sources,omitempty"`
+
+	Options *GenerationOptions `json:"options,omitempty"`
+
+	Prompt        string `json:"prompt,omitempty"`
+	PromptVersion int    `json:"prompt_version,omitempty"`
+	Language      string `json:"language,omitempty"`
+
+	Rewrite     *bool `json:"rewrite,omitempty"`
+	Paraphrases *int  `json:"paraphrases,omitempty"`
+}
+
+// ChatResponse represents the assistant's reply in a chat session
+type ChatResponse struct {
+	SessionID      int          `json:"sessionId"`
+	Message        ChatMessage  `json:"message"`
+	Sources        QuerySources `json:"sources"`
+	ModelUsed      string       `json:"modelUsed"`
+	ProcessingTime float64      `json:"processingTime"`
+
+	Citations        []Citation      `json:"citations"`
+	InvalidCitations []int           `json:"invalidCitations,omitempty"`
+	DroppedSources   []DroppedSource `json:"droppedSources,omitempty"`
+	Rewrites         *QueryRewrite   `json:"rewrites,omitempty"`
+}
+
+// PromptTemplate represents one version of a named prompt template
+type PromptTemplate struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Version     int    `json:"version"`
+	Description string `json:"description,omitempty"`
+	Template    string `json:"template"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+// PromptTemplateRequest represents a new prompt template or a new version of one
+type PromptTemplateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Template    string `json:"template" binding:"required"`
+}
+
+// DownloadJob represents a model file download running in the background
+type DownloadJob struct {
+	Name       string  `json:"name"`
+	URL        string  `json:"url"`
+	Status     string  `json:"status"`
+	BytesDone  int64   `json:"bytesDone"`
+	BytesTotal int64   `json:"bytesTotal,omitempty"` // 0 while unknown
+	Progress   float64 `json:"progress"`
+	Rate       float64 `json:"rate"`          // bytes per second
+	ETA        float64 `json:"eta,omitempty"` // seconds left, 0 while unknown
+	Error      string  `json:"error,omitempty"`
+	StartedAt  string  `json:"startedAt"`
+	FinishedAt string  `json:"finishedAt,omitempty"`
 }
 
 // Request types
 type DownloadModelRequest struct {
-	Name string `json:"name" binding:"required"`
-	URL  string `json:"url" binding:"required"`
+	Name   string `json:"name" binding:"required"`
+	URL    string `json:"url" binding:"required"`
+	SHA256 string `json:"sha256" binding:"omitempty,hexadecimal,len=64"`
+}
+
+// ImportModelRequest represents a request to create a backend model from a
+// GGUF file in the models directory. Empty fields are filled in from the
+// file and the generation defaults.
+type ImportModelRequest struct {
+	File       string             `json:"file" binding:"required"`
+	Name       string             `json:"name"` // derived from the file name when empty
+	Template   string             `json:"template"`
+	System     string             `json:"system"`
+	Parameters *GenerationOptions `json:"parameters"`
 }
 
 type LoadModelRequest struct {
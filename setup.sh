@@ -62,9 +62,11 @@ local-ai-project/
 ```bash
 cd backend
 go mod tidy
-go run cmd/server/main.go
+go run -tags sqlite_fts5 cmd/server/main.go
 ```
 
+`sqlite_fts5` etiketi dokuman parcalari icin FTS5 tam metin indeksini (BM25) etkinlestirir. Etiket olmadan anahtar kelime aramasi basit `LIKE` aramasina geri doner.
+
 ### Frontend
 ```bash
 cd frontend